@@ -4,17 +4,27 @@ import (
 	"context"
 	"flag"
 	"github.com/patrickmn/go-cache"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	"torn/model"
+	"torn/talert"
+	"torn/thealth"
 	"torn/thttp"
+	"torn/tlog"
+	"torn/tmetrics"
 	"torn/rethinkdb"
 	"torn/tproducer"
 	"torn/tconsumer"
 	"torn/treporter"
+	"torn/tcompact"
+	"torn/texport"
+	"torn/tdiscord"
+	"torn/twebhook"
 )
 
 /*
@@ -24,26 +34,32 @@ Features:
 	* Retrieve User
 	* Determine If User Updated
 	* Publish User to Kafka
-	* Alert: Torn API Down
-	* Alert: Unable to Publish to Kafka
-	* Alert: Haven't Published to Kafka in 30 Seconds
+	* Alert: Torn API Down (talert)
+	* Alert: Unable to Publish to Kafka (talert)
+	* Alert: Haven't Published to Kafka in 30 Seconds (talert)
 */
 
 type Args struct {
 	Consumer *tconsumer.Args
-	Producer *ProducerArgs
+	Producer *tproducer.Args
 	Report   *treporter.Args
+	Compact  *tcompact.Args
+	Retention *tcompact.RetentionArgs
+	Export   *texport.Args
+	Discord  *tdiscord.Args
 	Server   *ServerArgs
+	OpsPort  string
+	Health   *thealth.Checker
 }
 
 type ServerArgs struct {
 	RethinkdbServer string
 	Port string
-}
-
-type ProducerArgs struct {
-	BootstrapServer string
-	ApiKeys []string
+	FactionId uint
+	AnomalyPolicy string
+	AdminToken string
+	Alert talert.Args // notifiers for goals hit
+	GoalCheckInterval time.Duration
 }
 
 func ParseCliArgs() Args {
@@ -53,32 +69,191 @@ func ParseCliArgs() Args {
 	var consumer bool
 	var reporter bool
 	var server bool
+	var chains bool
 	var port string
+	var attacksApiKey string
+	var factionId uint
+	var anomalyPolicy string
+	var adminToken string
+	var alertArgs talert.Args
+	var opsPort string
+	var logLevel string
+	var logJson bool
+	var energyRulesPath string
+	var itemAllowlist string
+	var competitionsPath string
+	var keyframeInterval int
+	var compact bool
+	var dryRun bool
+	var goalCheckInterval time.Duration
+	var discord tdiscord.Args
+	var discordBot bool
+	var storedKeys bool
+	var webhooks bool
+	var webhookRetries int
+	var prune bool
+	var retention tcompact.RetentionArgs
+	var export texport.Args
+	var exportFrom string
+	var exportTo string
 	flag.StringVar(&bootstrapServer, "bootstrap-server", "127.0.0.1", "Kafka bootstrap server")
 	flag.StringVar(&rethinkDbServer, "rethinkdb-server", "127.0.0.1", "RethinkDB server")
 	flag.StringVar(&port, "port", ":80", "Server port")
+	flag.StringVar(&opsPort, "ops-port", "", "Port serving /metrics, /healthz and /readyz in non-server modes (disabled when empty)")
+	flag.StringVar(&attacksApiKey, "attacks-key", "", "API key with faction access used to poll faction attacks")
+	flag.UintVar(&factionId, "faction-id", 0, "Faction whose chains are reported (0 for every chain hit)")
+	flag.StringVar(&anomalyPolicy, "anomaly-policy", model.AnomalyPolicyCap, "How suspicious diffs are counted: include, exclude or cap")
+	flag.StringVar(&adminToken, "admin-token", "", "Bearer token required by /admin endpoints and changes to other members' goals (closed when empty)")
+	flag.StringVar(&alertArgs.DiscordWebhook, "alert-discord-webhook", "", "Discord webhook URL alerts are sent to")
+	flag.StringVar(&alertArgs.SlackWebhook, "alert-slack-webhook", "", "Slack webhook URL alerts are sent to")
+	flag.StringVar(&alertArgs.Webhook, "alert-webhook", "", "URL alerts are POSTed to as JSON")
+	flag.BoolVar(&alertArgs.Stdout, "alert-stdout", true, "Prints alerts to stdout")
+	flag.DurationVar(&alertArgs.StaleAfter, "alert-stale-after", time.Second*30, "Alerts when no poll has published its User or found it unchanged for this long")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum log level: debug, info, warn or error (full documents are only logged at debug)")
+	flag.BoolVar(&logJson, "log-json", false, "Writes logs as JSON")
+	flag.StringVar(&energyRulesPath, "energy-rules", "", "JSON file of energy rules with effective-from dates (built-in values when empty)")
+	flag.StringVar(&competitionsPath, "competitions", "", "JSON file of competitions with their teams (built-in weeks when empty)")
+	flag.StringVar(&itemAllowlist, "item-allowlist", "", "Comma-separated inventory item IDs to keep (all items when empty)")
+	flag.IntVar(&keyframeInterval, "keyframe-interval", rethinkdb.DefaultKeyframeInterval, "Snapshots stored per keyframe; the rest are stored as deltas (1 stores every snapshot in full)")
+	flag.DurationVar(&goalCheckInterval, "goal-check-interval", time.Minute*5, "How often the server checks whether goals were hit")
+	flag.StringVar(&discord.PublicKey, "discord-public-key", "", "Hex public key Discord signs interactions with")
+	flag.StringVar(&discord.ApplicationId, "discord-application-id", "", "Discord application ID")
+	flag.StringVar(&discord.BotToken, "discord-bot-token", "", "Discord bot token, used to register commands")
+	flag.StringVar(&discord.ApiBase, "discord-api", tdiscord.DefaultApiBase, "Discord HTTP API base URL")
+	flag.BoolVar(&discord.RegisterCommands, "discord-register-commands", false, "Registers the bot's slash commands on startup")
+	flag.BoolVar(&storedKeys, "stored-keys", false, "Also polls API keys registered through the Discord bot")
+	flag.BoolVar(&webhooks, "webhooks", false, "Sends events from the consumer to webhook subscribers")
+	flag.IntVar(&webhookRetries, "webhook-retries", twebhook.DefaultRetries, "Retries per webhook delivery, with exponential backoff")
+	flag.BoolVar(&dryRun, "dry-run", false, "Reports what -compact or -prune would do without changing rows")
+	flag.DurationVar(&retention.MaxAge, "retention-max-age", time.Hour*24*90, "Snapshots older than this are downsampled by -prune")
+	flag.DurationVar(&retention.Resolution, "retention-resolution", time.Hour*24, "One old snapshot is kept per interval (0 keeps only edges and events)")
+	flag.StringVar(&retention.ArchiveDir, "retention-archive-dir", "archive", "Directory pruned snapshots are archived to as gzipped JSON lines")
+	flag.StringVar(&export.Kind, "export", "", "Exports snapshots, diffs or leaderboard, then exits")
+	flag.StringVar(&export.Format, "export-format", texport.FormatJsonLines, "Export format: jsonl, csv or parquet")
+	flag.StringVar(&export.Board, "export-board", treporter.BoardEnergy, "Leaderboard exported with -export leaderboard")
+	flag.StringVar(&exportFrom, "export-from", "2019-08-24", "First day exported (YYYY-MM-DD)")
+	flag.StringVar(&exportTo, "export-to", "2019-09-07", "Day the export ends before (YYYY-MM-DD)")
+	flag.StringVar(&export.Output, "export-output", "-", "File the export is written to (stdout when -)")
 	flag.BoolVar(&consumer, "consumer", false, "Runs app in consumer mode")
 	flag.BoolVar(&reporter, "reporter", false, "Runs app in reporter mode")
 	flag.BoolVar(&server, "server", false, "Runs app in server mode")
+	flag.BoolVar(&discordBot, "discord", false, "Runs app in Discord bot mode, serving interactions on -port")
+	flag.BoolVar(&chains, "chains", false, "Runs app in chain report mode")
+	flag.BoolVar(&prune, "prune", false, "Downsamples and archives old snapshots, keeping competition edges and events, then exits")
+	flag.BoolVar(&compact, "compact", false, "Rewrites stored snapshots as keyframes and deltas, then exits")
 	flag.Parse()
+	if err := tlog.Configure(logLevel, logJson); err != nil {
+		tlog.Fatalf("Invalid log level: %s", logLevel)
+	}
+	if !model.IsAnomalyPolicy(anomalyPolicy) {
+		tlog.Fatalf("Invalid anomaly policy: %s", anomalyPolicy)
+	}
+	if energyRulesPath != "" {
+		rules, err := model.LoadEnergyRuleSet(energyRulesPath)
+		if err != nil {
+			tlog.Fatalf("Unable to load energy rules: %s", err)
+		}
+		model.ActiveEnergyRules = rules
+	}
+	if competitionsPath != "" {
+		competitions, err := model.LoadCompetitions(competitionsPath)
+		if err != nil {
+			tlog.Fatalf("Unable to load competitions: %s", err)
+		}
+		model.ActiveCompetitions = competitions
+	}
+	if itemAllowlist != "" {
+		model.ItemAllowlist = make(map[int]bool)
+		for _, id := range strings.Split(itemAllowlist, ",") {
+			itemId, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				tlog.Fatalf("Invalid item ID in allowlist: %s", id)
+			}
+			model.ItemAllowlist[itemId] = true
+		}
+	}
+	if export.Kind != "" {
+		if !texport.IsKind(export.Kind) {
+			tlog.Fatalf("Invalid export kind: %s", export.Kind)
+		}
+		if !texport.IsFormat(export.Format) {
+			tlog.Fatalf("Invalid export format: %s", export.Format)
+		}
+		var ferr, terr error
+		export.Earliest, ferr = time.Parse("2006-01-02", exportFrom)
+		export.Latest, terr = time.Parse("2006-01-02", exportTo)
+		if ferr != nil || terr != nil {
+			tlog.Fatalf("Invalid export date range: from=%s, to=%s", exportFrom, exportTo)
+		}
+	}
+	args := Args{OpsPort: opsPort, Health: thealth.NewChecker()}
 	if consumer {
-		consumerArgs := tconsumer.Args{BootstrapServer: bootstrapServer, RethinkdbServer: rethinkDbServer}
-		return Args{Consumer: &consumerArgs}
-	} else if reporter {
-		args := treporter.Args{RethinkdbServer: rethinkDbServer}
-		return Args{Report: &args}
+		args.Consumer = &tconsumer.Args{BootstrapServer: bootstrapServer, RethinkdbServer: rethinkDbServer, Health: args.Health, KeyframeInterval: keyframeInterval,
+			Webhooks: webhooks, WebhookRetries: webhookRetries}
+	} else if compact {
+		args.Compact = &tcompact.Args{RethinkdbServer: rethinkDbServer, KeyframeInterval: keyframeInterval, DryRun: dryRun}
+	} else if export.Kind != "" {
+		export.RethinkdbServer = rethinkDbServer
+		export.AnomalyPolicy = anomalyPolicy
+		args.Export = &export
+	} else if prune {
+		retention.RethinkdbServer = rethinkDbServer
+		retention.KeyframeInterval = keyframeInterval
+		retention.Edges = model.ActiveCompetitions.Boundaries()
+		retention.DryRun = dryRun
+		args.Retention = &retention
+	} else if discordBot {
+		discord.RethinkdbServer = rethinkDbServer
+		discord.Port = port
+		discord.FactionId = factionId
+		discord.AnomalyPolicy = anomalyPolicy
+		args.Discord = &discord
+	} else if reporter || chains {
+		args.Report = &treporter.Args{RethinkdbServer: rethinkDbServer, FactionId: factionId, Chains: chains, AnomalyPolicy: anomalyPolicy}
 	} else if server {
-		args := ServerArgs{
+		args.Server = &ServerArgs{
 			RethinkdbServer: rethinkDbServer,
 			Port:            port,
+			FactionId:       factionId,
+			AnomalyPolicy:   anomalyPolicy,
+			AdminToken:      adminToken,
+			Alert:           alertArgs,
+			GoalCheckInterval: goalCheckInterval,
+		}
+	} else {
+		// Producer mode
+		apiKeys := flag.Args()
+		var keysServer string
+		if storedKeys {
+			keysServer = rethinkDbServer
+		}
+		args.Producer = &tproducer.Args{
+			BootstrapServer: bootstrapServer,
+			ApiKeys:         apiKeys,
+			AttacksApiKey:   attacksApiKey,
+			RethinkdbServer: keysServer,
+			Alert:           alertArgs,
+			Health:          args.Health,
 		}
-		return Args{Server: &args}
 	}
-	// Producer mode
-	apiKeys := flag.Args()
-	producerArgs := ProducerArgs{BootstrapServer: bootstrapServer, ApiKeys: apiKeys}
-	return Args{Producer: &producerArgs}
+	return args
+}
+
+func StartHttpServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		// returns ErrServerClosed on graceful close
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			// NOTE: there is a chance that next line won't have time to run,
+			// as main() doesn't wait for this goroutine to stop. don't use
+			// code with race conditions like these for production. see post
+			// comments below on more discussion on how to handle this.
+			tlog.Fatalf("ListenAndServe(): %s", err)
+		}
+	}()
+	return srv
 }
+
 func CreateIntTermChannel() chan bool {
 	// Signal handling
 	sigs := make(chan os.Signal, 1)
@@ -87,8 +262,7 @@ func CreateIntTermChannel() chan bool {
 
 	go func() {
 		sig := <-sigs
-		log.Println()
-		log.Println("Received termination signal: ", sig)
+		tlog.Infof("Received termination signal: %s", sig)
 		done <- true
 	}()
 	return done
@@ -98,44 +272,92 @@ func main() {
 	args := ParseCliArgs()
 	intTermChan := CreateIntTermChannel()
 
-	log.Println("Application initialised; awaiting termination signal.")
+	tlog.Infof("Application initialised; awaiting termination signal.")
+	if args.OpsPort != "" && args.Server == nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", tmetrics.Handler())
+		mux.HandleFunc("/healthz", args.Health.LivenessHandler)
+		mux.HandleFunc("/readyz", args.Health.ReadinessHandler)
+		ops := StartHttpServer(args.OpsPort, mux)
+		defer ops.Shutdown(context.TODO())
+	}
 	if args.Producer != nil {
-		log.Println("Running in producer mode.")
-		tproducer.RunProducer(args.Producer.BootstrapServer, args.Producer.ApiKeys, intTermChan)
+		tlog.Infof("Running in producer mode.")
+		tproducer.RunProducer(*args.Producer, intTermChan)
 	} else if args.Consumer != nil {
-		log.Println("Running in consumer mode.")
+		tlog.Infof("Running in consumer mode.")
 		tconsumer.RunConsumer(*args.Consumer, intTermChan)
 	} else if args.Report != nil {
-		log.Println("Running in reporter mode.")
+		tlog.Infof("Running in reporter mode.")
 		treporter.RunReport(*args.Report, intTermChan)
+	} else if args.Compact != nil {
+		tlog.Infof("Running in compaction mode.")
+		tcompact.RunCompaction(*args.Compact, intTermChan)
+	} else if args.Export != nil {
+		tlog.Infof("Running in export mode.")
+		if err := texport.RunExport(*args.Export, intTermChan); err != nil {
+			os.Exit(1)
+		}
+	} else if args.Retention != nil {
+		tlog.Infof("Running in retention mode.")
+		tcompact.RunRetention(*args.Retention, intTermChan)
+	} else if args.Discord != nil {
+		tlog.Infof("Running in Discord bot mode.")
+		tdiscord.RunBot(*args.Discord, intTermChan)
 	} else if args.Server != nil {
-		log.Println("Running in server mode.")
+		tlog.Infof("Running in server mode.")
 		cash := cache.New(time.Second * 3, time.Second * 3)
 		session := rethinkdb.SetUpDb(args.Server.RethinkdbServer)
 		defer session.Close()
 		userDao := rethinkdb.UserDao{Session: session}
-		reporter := treporter.Reporter{UserDao: &userDao}
-		server := thttp.Server{Cache: cash, Reporter: &reporter}
+		attackDao := rethinkdb.AttackDao{Session: session}
+		reporter := treporter.Reporter{
+			UserDao:       &userDao,
+			AttackDao:     &attackDao,
+			FactionId:     args.Server.FactionId,
+			AnomalyPolicy: args.Server.AnomalyPolicy,
+		}
+		goalDao := rethinkdb.GoalDao{Session: session}
+		webhookDao := rethinkdb.WebhookDao{Session: session}
+		server := thttp.Server{Cache: cash, Reporter: &reporter, GoalDao: &goalDao, WebhookDao: &webhookDao, AdminToken: args.Server.AdminToken,
+			TornClient: thttp.NewTornClient()}
+		if args.Server.AdminToken == "" {
+			tlog.Warnf("No -admin-token set, admin endpoints will reject every request")
+		}
 		server.RefreshCachePeriodically()
+		goalChecker := treporter.GoalChecker{Reporter: &reporter, GoalDao: &goalDao, Notifiers: talert.NewNotifiersFromArgs(args.Server.Alert)}
+		goalsDone := make(chan bool)
+		go goalChecker.Run(args.Server.GoalCheckInterval, goalsDone)
 		mux := http.NewServeMux()
 		mux.HandleFunc("/", server.Handler)
-		srv := &http.Server{Addr: args.Server.Port, Handler: mux}
-		go func() {
-			// returns ErrServerClosed on graceful close
-			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-				// NOTE: there is a chance that next line won't have time to run,
-				// as main() doesn't wait for this goroutine to stop. don't use
-				// code with race conditions like these for production. see post
-				// comments below on more discussion on how to handle this.
-				log.Fatalf("ListenAndServe(): %s", err)
-			}
-		}()
+		mux.HandleFunc("/chains", server.ChainHandler)
+		mux.HandleFunc("/jumps", server.HappyJumpHandler)
+		mux.HandleFunc("/items", server.ItemLedgerHandler)
+		mux.HandleFunc("/api/leaderboard", server.LeaderboardApiHandler)
+		mux.HandleFunc("/api/timeseries", server.TimeSeriesApiHandler)
+		mux.HandleFunc("/leaderboard", server.LeaderboardPageHandler)
+		mux.HandleFunc("/user", server.UserPageHandler)
+		mux.HandleFunc("/chart", server.ChartHandler)
+		mux.HandleFunc("/api/goals", server.GoalsApiHandler)
+		mux.HandleFunc("/admin/anomalies", server.AnomalyHandler)
+		mux.HandleFunc("/admin/export", server.ExportHandler)
+		mux.HandleFunc("/admin/webhooks", server.WebhooksHandler)
+		mux.HandleFunc("/admin/webhooks/deliveries", server.WebhookDeliveriesHandler)
+		mux.Handle("/metrics", tmetrics.Handler())
+		args.Health.AddLiveness("rethinkdb", func() error {
+			return rethinkdb.CheckSession(session)
+		})
+		args.Health.AddReadiness("leaderboard-cache", server.CheckCachePopulated)
+		mux.HandleFunc("/healthz", args.Health.LivenessHandler)
+		mux.HandleFunc("/readyz", args.Health.ReadinessHandler)
+		srv := StartHttpServer(args.Server.Port, mux)
 		<- intTermChan
+		close(goalsDone)
 		if err := srv.Shutdown(context.TODO()); err != nil {
 			panic(err) // failure/timeout shutting down the server gracefully
 		}
 	} else {
-		log.Println("Invalid arguments provided")
+		tlog.Errorf("Invalid arguments provided")
 	}
-	log.Println("Application stopping.")
+	tlog.Infof("Application stopping.")
 }
\ No newline at end of file
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	AnomalyPolicyInclude = "include" // count the diff as calculated
+	AnomalyPolicyExclude = "exclude" // drop the diff from the summary
+	AnomalyPolicyCap     = "cap"     // clamp energy trained to [0, plausible maximum]
+)
+
+// Ratio either side of a user's median gains per energy beyond which a train is suspicious
+const GainsPerEnergyTolerance = 20
+
+type Anomaly struct {
+	UserId    uint      `json:"userId"`
+	Name      string    `json:"name,omitempty"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Energy    int       `json:"energy"`
+	MaxEnergy int       `json:"maxEnergy"` // plausible maximum for the interval
+	Gains     string    `json:"gains"`
+	Reasons   []string  `json:"reasons"`
+}
+
+func IsAnomalyPolicy(policy string) bool {
+	return policy == AnomalyPolicyInclude || policy == AnomalyPolicyExclude || policy == AnomalyPolicyCap
+}
+
+// Generous upper bound on energy available to a diff, assuming every source was used
+// as often as its cooldown allows over the elapsed time
+func (u UserDiff) MaxPlausibleEnergy() int {
+	elapsed := u.Elapsed
+	maxEnergy := u.MaxEnergy
+	if maxEnergy <= 0 {
+		maxEnergy = 150
+	}
+	regenInterval := time.Minute * 15
+	if maxEnergy >= 150 {
+		regenInterval = time.Minute * 10
+	}
+	regen := 5 * (1 + int(elapsed/regenInterval))
+	days := 1 + int(elapsed/(time.Hour*24))
+	drugs := 1 + int(elapsed/(time.Hour*6))
+	boosters := 4 + int(elapsed/(time.Hour*6))
+	cans := 5 + int(elapsed/(time.Hour*2))
+	jpEnergy, _ := u.CalculateEnergyGainedFromJobPoints()
+	refills := days
+	if used := u.CalculateRefills().Total; used > refills {
+		// Special refills aren't limited to one a day
+		refills = used
+	}
+	rules := u.EnergyRules()
+	return u.Bars.Energy.Previous + regen + refills*maxEnergy + rules.Xanax*drugs + rules.Lsd*drugs + rules.Fhc*boosters +
+		rules.EnergyDrink*cans + jpEnergy
+}
+
+// Returns reasons the diff looks implausible, if any; requires a diff from DiffAt
+func (u UserDiff) Validate() []string {
+	var reasons []string
+	trained := u.CalculateEnergyTrained()
+	gains, _ := u.BattleStats.GetTotalGains().Float64()
+	if trained < 0 {
+		reasons = append(reasons, fmt.Sprintf("negative energy trained (%d)", trained))
+	}
+	if u.IsTrain() && trained == 0 {
+		reasons = append(reasons, "stats gained without energy trained")
+	}
+	if gains < 0 {
+		reasons = append(reasons, "stats decreased")
+	}
+	if max := u.MaxPlausibleEnergy(); trained > max {
+		reasons = append(reasons, fmt.Sprintf("energy trained (%d) exceeds plausible maximum (%d) for %s", trained, max, u.Elapsed))
+	}
+	if u.IsTrain() && u.MaxEnergy <= 0 {
+		reasons = append(reasons, "missing max energy")
+	}
+	if u.Elapsed < 0 {
+		reasons = append(reasons, "snapshots out of order")
+	}
+	return reasons
+}
+
+// Compares a train's gains per energy against the user's median
+func ValidateGainsPerEnergy(gains float64, trained int, median float64) []string {
+	if trained <= 0 || gains <= 0 || median <= 0 {
+		return nil
+	}
+	ratio := gains / float64(trained)
+	if ratio > median*GainsPerEnergyTolerance || ratio < median/GainsPerEnergyTolerance {
+		return []string{fmt.Sprintf("gains per energy (%.2f) far from user median (%.2f)", ratio, median)}
+	}
+	return nil
+}
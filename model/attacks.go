@@ -2,6 +2,8 @@ package model
 
 import (
 	"encoding/json"
+	"sort"
+	"strconv"
 )
 
 type AttacksResponse struct {
@@ -36,4 +38,34 @@ type AttackModifiers struct {
 	GroupAttack Float32 `json:"groupAttack,omitempty"`
 	Overseas    Float32 `json:"overseas,omitempty"`
 	ChainBonus  Float32 `json:"chainBonus,omitempty"`
-}
\ No newline at end of file
+}
+
+func (a Attacks) ToAttacks() ([]Attack, error) {
+	var attacks []Attack
+	for id, msg := range a {
+		var attack Attack
+		if err := json.Unmarshal([]byte(*msg), &attack); err != nil {
+			return nil, err
+		}
+		// Older API responses only carry the ID as the map key
+		if attack.Id == 0 {
+			parsed, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			attack.Id = uint(parsed)
+		}
+		attacks = append(attacks, attack)
+	}
+	SortAttacks(attacks)
+	return attacks, nil
+}
+
+func SortAttacks(attacks []Attack) {
+	sort.SliceStable(attacks, func(i, j int) bool {
+		if attacks[i].Ended == attacks[j].Ended {
+			return attacks[i].Id < attacks[j].Id
+		}
+		return attacks[i].Ended < attacks[j].Ended
+	})
+}
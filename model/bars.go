@@ -1,5 +1,9 @@
 package model
 
+import (
+	"time"
+)
+
 type Energy struct {
 	Previous int `json:"previous,omitempty"`
 	Current int `json:"current,omitempty"`
@@ -8,6 +12,77 @@ type Energy struct {
 	TickTime int `json:"ticktime,omitempty"`
 }
 
+// Seconds between 5e regen ticks
+func (e Energy) RegenInterval() int {
+	if e.Maximum >= 150 {
+		return 600
+	}
+	return 900
+}
+
+// Estimates energy regenerated over elapsed from this snapshot. Regen stops at the maximum
+// and the snapshots don't say when energy was spent, so at most the headroom is counted
+func (e Energy) EstimateRegen(elapsed time.Duration) int {
+	return estimateRegen(e.Current, e.Maximum, e.TickTime, e.RegenInterval(), 5, elapsed)
+}
+
+// Whether energy spent in the interval could have freed room for regen that isn't counted
+func (e Energy) RegenUncertain(elapsed time.Duration, spent bool) bool {
+	return spent && e.Current >= e.Maximum && elapsed >= time.Duration(e.RegenInterval())*time.Second
+}
+
+func estimateRegen(current int, maximum int, tickTime int, interval int, increment int, elapsed time.Duration) int {
+	seconds := int(elapsed / time.Second)
+	headroom := maximum - current
+	if seconds <= 0 || seconds < tickTime || headroom <= 0 {
+		return 0
+	}
+	ticks := seconds / interval
+	if tickTime > 0 {
+		ticks = 1 + (seconds-tickTime)/interval
+	}
+	regen := increment * ticks
+	if regen > headroom {
+		regen = headroom
+	}
+	return regen
+}
+
+type Nerve struct {
+	Previous int `json:"previous,omitempty"`
+	Current int `json:"current,omitempty"`
+	Diff int `json:"diff,omitempty"`
+	Maximum int `json:"maximum,omitempty"`
+	TickTime int `json:"ticktime,omitempty"`
+}
+
+// 1 nerve every 5 minutes, up to the maximum
+func (n Nerve) EstimateRegen(elapsed time.Duration) int {
+	return estimateRegen(n.Current, n.Maximum, n.TickTime, 300, 1, elapsed)
+}
+
+// Whether nerve spent in the interval could have freed room for regen that isn't counted
+func (n Nerve) RegenUncertain(elapsed time.Duration, spent bool) bool {
+	return spent && n.Current >= n.Maximum && elapsed >= time.Minute*5
+}
+
+type Life struct {
+	Previous int `json:"previous,omitempty"`
+	Current int `json:"current,omitempty"`
+	Diff int `json:"diff,omitempty"`
+	Maximum int `json:"maximum,omitempty"`
+}
+
+// The faction chain the user belongs to, as seen by the bars selection
+type ChainBar struct {
+	Previous int `json:"previous,omitempty"`
+	Current int `json:"current,omitempty"`
+	Diff int `json:"diff,omitempty"`
+	Maximum int `json:"maximum,omitempty"`
+	Timeout int `json:"timeout,omitempty"`
+	Cooldown int `json:"cooldown,omitempty"`
+}
+
 type Happy struct {
 	Previous int `json:"previous,omitempty"`
 	Current int `json:"current,omitempty"`
@@ -18,11 +93,16 @@ type Happy struct {
 type Bars struct {
 	Energy Energy `json:"energy,omitempty"`
 	Happy Happy `json:"happy,omitempty"`
+	Nerve Nerve `json:"nerve,omitempty"`
+	Life Life `json:"life,omitempty"`
+	Chain ChainBar `json:"chain,omitempty"`
 }
 
+// Life and chain move on their own (regen, faction hits) and don't warrant a new snapshot
 func (b Bars) Equals(b2 Bars) bool {
 	return b.Energy.Current == b2.Energy.Current &&
-		b.Happy.Current == b2.Happy.Current
+		b.Happy.Current == b2.Happy.Current &&
+		b.Nerve.Current == b2.Nerve.Current
 }
 
 func (b Bars) Diff(b2 Bars) Bars {
@@ -35,5 +115,17 @@ func (b Bars) Diff(b2 Bars) Bars {
 	diff.Happy.Previous = b.Happy.Current
 	diff.Happy.Current = b2.Happy.Current
 	diff.Happy.Diff = b2.Happy.Current - b.Happy.Current
+
+	diff.Nerve.Previous = b.Nerve.Current
+	diff.Nerve.Current = b2.Nerve.Current
+	diff.Nerve.Diff = b2.Nerve.Current - b.Nerve.Current
+
+	diff.Life.Previous = b.Life.Current
+	diff.Life.Current = b2.Life.Current
+	diff.Life.Diff = b2.Life.Current - b.Life.Current
+
+	diff.Chain.Previous = b.Chain.Current
+	diff.Chain.Current = b2.Chain.Current
+	diff.Chain.Diff = b2.Chain.Current - b.Chain.Current
 	return diff
 }
\ No newline at end of file
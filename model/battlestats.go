@@ -48,6 +48,25 @@ func (bs BattleStats) GetTotalGains() *big.Float {
 	return sum
 }
 
+func (bs BattleStats) Total() float64 {
+	total, _ := bs.GetTotalGains().Float64()
+	return total
+}
+
+// Should only be run on diff
+func (bs BattleStats) AddToSummary(summary *UserSummary) {
+	str, _ := ToFloat(bs.Strength).Float64()
+	spd, _ := ToFloat(bs.Speed).Float64()
+	dex, _ := ToFloat(bs.Dexterity).Float64()
+	def, _ := ToFloat(bs.Defense).Float64()
+	summary.StrengthGains += str
+	summary.SpeedGains += spd
+	summary.DexterityGains += dex
+	summary.DefenseGains += def
+	total, _ := bs.GetTotalGains().Float64()
+	summary.TotalGains += total
+}
+
 func ToFloat(value string) *big.Float {
 	if value == "" {
 		value = "0"
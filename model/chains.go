@@ -0,0 +1,133 @@
+package model
+
+import (
+	"sort"
+)
+
+// Seconds a chain survives without a hit
+const ChainTimeout = 300
+
+// Gaps at least this long (seconds) are reported as close calls
+const ChainGapWarning = 120
+
+// Milestones Torn pays bonus respect on, only used for attacks stored without modifiers
+var ChainBonusHits = map[uint64]bool{
+	10: true, 25: true, 50: true, 100: true, 250: true, 500: true, 1000: true,
+	2500: true, 5000: true, 10000: true, 25000: true, 50000: true, 100000: true,
+}
+
+type ChainMember struct {
+	UserId    uint    `json:"userId"`
+	Name      string  `json:"name,omitempty"`
+	Hits      int     `json:"hits"`
+	Respect   float64 `json:"respect"`
+	BonusHits int     `json:"bonusHits"`
+	FairFight float64 `json:"fairFight"` // average over Hits
+}
+
+type ChainGap struct {
+	After   uint64 `json:"after"` // chain count when the gap started
+	Seconds uint   `json:"seconds"`
+}
+
+type Chain struct {
+	Started   uint          `json:"started"`
+	Ended     uint          `json:"ended"`
+	Length    uint64        `json:"length"`
+	Respect   float64       `json:"respect"`
+	BonusHits int           `json:"bonusHits"`
+	TimedOut  bool          `json:"timedOut"` // a later hit shows the chain was dropped
+	Members   []ChainMember `json:"members"`
+	Gaps      []ChainGap    `json:"gaps,omitempty"`
+}
+
+type chainBuilder struct {
+	chain     Chain
+	members   map[uint]*ChainMember
+	fairFight map[uint]float64
+}
+
+func newChainBuilder(a Attack) *chainBuilder {
+	return &chainBuilder{
+		chain:     Chain{Started: a.Started},
+		members:   make(map[uint]*ChainMember),
+		fairFight: make(map[uint]float64),
+	}
+}
+
+func (cb *chainBuilder) add(a Attack) {
+	if cb.chain.Ended > 0 && a.Ended-cb.chain.Ended >= ChainGapWarning {
+		cb.chain.Gaps = append(cb.chain.Gaps, ChainGap{cb.chain.Length, a.Ended - cb.chain.Ended})
+	}
+	member, ok := cb.members[a.AttackerId]
+	if !ok {
+		member = &ChainMember{UserId: a.AttackerId}
+		cb.members[a.AttackerId] = member
+	}
+	member.Name = a.AttackerName
+	member.Hits += 1
+	member.Respect += a.RespectGain.Float64()
+	cb.fairFight[a.AttackerId] += a.AttackModifiers.FairFight.Float64()
+	cb.chain.Respect += a.RespectGain.Float64()
+	if IsChainBonusHit(a) {
+		member.BonusHits += 1
+		cb.chain.BonusHits += 1
+	}
+	cb.chain.Ended = a.Ended
+	cb.chain.Length = a.Chain
+}
+
+// Torn reports a chain bonus of 1 on ordinary hits and the bonus multiplier on bonus hits;
+// attacks fetched before modifiers were stored (no fair fight either) are matched against
+// the milestones instead
+func IsChainBonusHit(a Attack) bool {
+	if a.AttackModifiers.FairFight.Value == "" && a.AttackModifiers.ChainBonus.Value == "" {
+		return ChainBonusHits[a.Chain]
+	}
+	return a.AttackModifiers.ChainBonus.Float64() > 1
+}
+
+func (cb *chainBuilder) build(timedOut bool) Chain {
+	chain := cb.chain
+	chain.TimedOut = timedOut
+	for id, member := range cb.members {
+		m := *member
+		m.FairFight = cb.fairFight[id] / float64(m.Hits)
+		chain.Members = append(chain.Members, m)
+	}
+	sort.SliceStable(chain.Members, func(i, j int) bool {
+		if chain.Members[i].Hits == chain.Members[j].Hits {
+			return chain.Members[i].Respect > chain.Members[j].Respect
+		}
+		return chain.Members[i].Hits > chain.Members[j].Hits
+	})
+	return chain
+}
+
+// Reconstructs chains from a faction's attack history; a factionId of 0 keeps every chain hit
+func BuildChains(attacks []Attack, factionId uint) []Chain {
+	var hits []Attack
+	for _, a := range attacks {
+		if a.Chain > 0 && (factionId == 0 || a.AttackerFactionId == factionId) {
+			hits = append(hits, a)
+		}
+	}
+	SortAttacks(hits)
+
+	var chains []Chain
+	var cur *chainBuilder
+	for _, a := range hits {
+		if cur != nil && (a.Chain <= cur.chain.Length || a.Ended-cur.chain.Ended > ChainTimeout) {
+			chains = append(chains, cur.build(true))
+			cur = nil
+		}
+		if cur == nil {
+			cur = newChainBuilder(a)
+		}
+		cur.add(a)
+	}
+	if cur != nil {
+		chains = append(chains, cur.build(false))
+	}
+	return chains
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func hit(id uint, attacker uint, ended uint, chain uint64, respect string) Attack {
+	return Attack{
+		Id:                id,
+		Started:           ended - 30,
+		Ended:             ended,
+		AttackerId:        attacker,
+		AttackerFactionId: 1,
+		Chain:             chain,
+		RespectGain:       Float32{respect},
+		AttackModifiers:   AttackModifiers{FairFight: Float32{"3"}},
+	}
+}
+
+func TestBuildChains(t *testing.T) {
+	attacks := []Attack{
+		hit(3, 20, 1200, 3, "2.5"),
+		hit(1, 10, 1000, 1, "1.5"),
+		hit(2, 10, 1150, 2, "2"),
+		// Timer ran out before this hit
+		hit(4, 10, 1600, 1, "1"),
+		// Other factions' chains are ignored
+		{Id: 5, Ended: 1610, AttackerId: 30, AttackerFactionId: 2, Chain: 2},
+	}
+	chains := BuildChains(attacks, 1)
+	if len(chains) != 2 {
+		t.Fatalf("BuildChains() got %d chains, want 2", len(chains))
+	}
+	first := chains[0]
+	if first.Length != 3 || !first.TimedOut || first.Respect != 6 {
+		t.Errorf("BuildChains() first = %+v", first)
+	}
+	if len(first.Members) != 2 || first.Members[0].UserId != 10 || first.Members[0].Hits != 2 || first.Members[0].FairFight != 3 {
+		t.Errorf("BuildChains() first members = %+v", first.Members)
+	}
+	if len(first.Gaps) != 1 || first.Gaps[0].After != 1 || first.Gaps[0].Seconds != 150 {
+		t.Errorf("BuildChains() first gaps = %+v", first.Gaps)
+	}
+	if second := chains[1]; second.Length != 1 || second.TimedOut || second.Started != 1570 {
+		t.Errorf("BuildChains() second = %+v", second)
+	}
+}
+
+// As the API returns them: ordinary chain hits carry a chain bonus of 1
+const CHAIN_ATTACKS = `[
+  {"id": 1, "timestamp_ended": 1000, "attacker_id": 10, "attacker_faction": 1, "chain": 9, "respect_gain": 2.5,
+   "modifiers": {"fairFight": 3, "war": 1, "retaliation": 1, "groupAttack": 1, "overseas": 1, "chainBonus": 1}},
+  {"id": 2, "timestamp_ended": 1060, "attacker_id": 20, "attacker_faction": 1, "chain": 10, "respect_gain": 25,
+   "modifiers": {"fairFight": 3, "war": 1, "retaliation": 1, "groupAttack": 1, "overseas": 1, "chainBonus": 10}},
+  {"id": 3, "timestamp_ended": 1120, "attacker_id": 10, "attacker_faction": 1, "chain": 11, "respect_gain": 2.5,
+   "modifiers": {"fairFight": 3, "war": 1, "retaliation": 1, "groupAttack": 1, "overseas": 1, "chainBonus": 1}}
+]`
+
+func TestIsChainBonusHit(t *testing.T) {
+	var attacks []Attack
+	if err := json.Unmarshal([]byte(CHAIN_ATTACKS), &attacks); err != nil {
+		t.Fatalf("Unable to unmarshal CHAIN_ATTACKS: %s", err)
+	}
+	for i, want := range []bool{false, true, false} {
+		if got := IsChainBonusHit(attacks[i]); got != want {
+			t.Errorf("IsChainBonusHit(chain %d) = %v, want %v", attacks[i].Chain, got, want)
+		}
+	}
+	chains := BuildChains(attacks, 1)
+	if len(chains) != 1 || chains[0].BonusHits != 1 {
+		t.Errorf("BuildChains() = %+v, want one chain with 1 bonus hit", chains)
+	}
+
+	bonus := hit(1, 10, 1000, 10, "40")
+	bonus.AttackModifiers.ChainBonus = Float32{"10"}
+	legacy := hit(2, 10, 1000, 25, "80")
+	legacy.AttackModifiers = AttackModifiers{}
+	tests := []struct {
+		name   string
+		attack Attack
+		want   bool
+	}{
+		{"reported bonus", bonus, true},
+		{"milestone without a reported bonus", hit(3, 10, 1000, 10, "2"), false},
+		{"legacy milestone", legacy, true},
+		{"regular hit", hit(4, 10, 1000, 11, "2"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsChainBonusHit(tt.attack); got != tt.want {
+				t.Errorf("IsChainBonusHit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A member counts towards their team from Joined until Left; zero times are open-ended
+type TeamMember struct {
+	UserId uint      `json:"userId"`
+	Joined time.Time `json:"joined,omitempty"`
+	Left   time.Time `json:"left,omitempty"`
+}
+
+type Team struct {
+	Name    string       `json:"name"`
+	Members []TeamMember `json:"members"`
+}
+
+type Competition struct {
+	Id    string    `json:"id"`
+	Name  string    `json:"name,omitempty"`
+	Begin time.Time `json:"begin"` // inclusive
+	End   time.Time `json:"end"`   // exclusive
+	Teams []Team    `json:"teams,omitempty"`
+
+	Scoring Scoring `json:"scoring,omitempty"`
+}
+
+// The part of the competition the member counts towards; false when they don't overlap
+func (c Competition) MemberRange(m TeamMember) (time.Time, time.Time, bool) {
+	begin, end := c.Begin, c.End
+	if m.Joined.After(begin) {
+		begin = m.Joined
+	}
+	if !m.Left.IsZero() && m.Left.Before(end) {
+		end = m.Left
+	}
+	return begin, end, begin.Before(end)
+}
+
+func (c Competition) Team(name string) (*Team, bool) {
+	for i := range c.Teams {
+		if c.Teams[i].Name == name {
+			return &c.Teams[i], true
+		}
+	}
+	return nil, false
+}
+
+type CompetitionSet []Competition
+
+func DefaultCompetitions() CompetitionSet {
+	times := []time.Time{
+		time.Date(2019, time.August, 24, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.August, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.September, 7, 0, 0, 0, 0, time.UTC),
+	}
+	return CompetitionSet{
+		{Id: "1", Name: "Week 1", Begin: times[0], End: times[1]},
+		{Id: "2", Name: "Week 2", Begin: times[1], End: times[2]},
+		{Id: "overall", Name: "Overall", Begin: times[0], End: times[2]},
+	}
+}
+
+// Competitions served and kept by retention; replaced at startup when a file is configured
+var ActiveCompetitions = DefaultCompetitions()
+
+func (cs CompetitionSet) Get(id string) (*Competition, error) {
+	for i := range cs {
+		if cs[i].Id == id {
+			return &cs[i], nil
+		}
+	}
+	return nil, errors.New("invalid input, expected one of: [" + strings.Join(cs.Ids(), ", ") + "]")
+}
+
+func (cs CompetitionSet) Ids() []string {
+	ids := make([]string, len(cs))
+	for i, c := range cs {
+		ids[i] = c.Id
+	}
+	return ids
+}
+
+// Competition shown when none is requested: the latest one to have started by now, or the
+// first when none has
+func (cs CompetitionSet) Default(now time.Time) string {
+	if len(cs) == 0 {
+		return ""
+	}
+	current := cs[0]
+	for _, c := range cs[1:] {
+		if !c.Begin.After(now) && c.Begin.After(current.Begin) {
+			current = c
+		}
+	}
+	return current.Id
+}
+
+// Competition running exactly from begin to end, whose scoring applies to that range
+func (cs CompetitionSet) Spanning(begin time.Time, end time.Time) (*Competition, bool) {
+	for i := range cs {
+		if cs[i].Begin.Equal(begin) && cs[i].End.Equal(end) {
+			return &cs[i], true
+		}
+	}
+	return nil, false
+}
+
+// Distinct begin and end times, including team joins and leaves, in order
+func (cs CompetitionSet) Boundaries() []time.Time {
+	seen := make(map[time.Time]bool)
+	var times []time.Time
+	add := func(t time.Time) {
+		if !t.IsZero() && !seen[t] {
+			seen[t] = true
+			times = append(times, t)
+		}
+	}
+	for _, c := range cs {
+		add(c.Begin)
+		add(c.End)
+		for _, team := range c.Teams {
+			for _, m := range team.Members {
+				add(m.Joined)
+				add(m.Left)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	return times
+}
+
+func overlaps(a TeamMember, b TeamMember) bool {
+	aEndsFirst := !a.Left.IsZero() && !a.Left.After(b.Joined)
+	bEndsFirst := !b.Left.IsZero() && !b.Left.After(a.Joined)
+	return !aEndsFirst && !bEndsFirst
+}
+
+func (cs CompetitionSet) Validate() error {
+	if len(cs) == 0 {
+		return errors.New("no competitions defined")
+	}
+	ids := make(map[string]bool)
+	for _, c := range cs {
+		if c.Id == "" {
+			return errors.New("competition without an id")
+		}
+		if ids[c.Id] {
+			return fmt.Errorf("duplicate competition id: %s", c.Id)
+		}
+		ids[c.Id] = true
+		if !c.End.After(c.Begin) {
+			return fmt.Errorf("competition %s ends before it begins", c.Id)
+		}
+		if err := c.Scoring.Validate(); err != nil {
+			return fmt.Errorf("competition %s: %s", c.Id, err)
+		}
+		teams := make(map[string]bool)
+		type membership struct {
+			team   string
+			member TeamMember
+		}
+		members := make(map[uint][]membership)
+		for _, team := range c.Teams {
+			if team.Name == "" || teams[team.Name] {
+				return fmt.Errorf("missing or duplicate team name in competition %s", c.Id)
+			}
+			teams[team.Name] = true
+			for _, m := range team.Members {
+				if !m.Left.IsZero() && !m.Left.After(m.Joined) {
+					return fmt.Errorf("user %d leaves team %s before joining", m.UserId, team.Name)
+				}
+				// Members may move between teams, but can't be in two at once
+				for _, other := range members[m.UserId] {
+					if overlaps(m, other.member) {
+						return fmt.Errorf("user %d is in teams %s and %s at once in competition %s", m.UserId, other.team, team.Name, c.Id)
+					}
+				}
+				members[m.UserId] = append(members[m.UserId], membership{team.Name, m})
+			}
+		}
+	}
+	return nil
+}
+
+// Reads a JSON array of Competitions
+func LoadCompetitions(path string) (CompetitionSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cs CompetitionSet
+	if err := json.Unmarshal(data, &cs); err != nil {
+		return nil, err
+	}
+	return cs, cs.Validate()
+}
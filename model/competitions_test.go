@@ -0,0 +1,101 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func day(d int) time.Time {
+	return time.Date(2019, time.August, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCompetitionSet_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		teams   []Team
+		wantErr bool
+	}{
+		{"no teams", nil, false},
+		{"transfer", []Team{
+			{Name: "Red", Members: []TeamMember{{UserId: 1, Left: day(27)}}},
+			{Name: "Blue", Members: []TeamMember{{UserId: 1, Joined: day(27)}}},
+		}, false},
+		{"two teams at once", []Team{
+			{Name: "Red", Members: []TeamMember{{UserId: 1, Left: day(28)}}},
+			{Name: "Blue", Members: []TeamMember{{UserId: 1, Joined: day(27)}}},
+		}, true},
+		{"duplicate team", []Team{{Name: "Red"}, {Name: "Red"}}, true},
+		{"leaves before joining", []Team{{Name: "Red", Members: []TeamMember{{UserId: 1, Joined: day(27), Left: day(26)}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := CompetitionSet{{Id: "1", Begin: day(24), End: day(31), Teams: tt.teams}}
+			if err := cs.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if err := (CompetitionSet{{Id: "1", Begin: day(31), End: day(24)}}).Validate(); err == nil {
+		t.Errorf("Validate() expected an error for a competition ending before it begins")
+	}
+}
+
+func TestCompetition_MemberRange(t *testing.T) {
+	c := Competition{Id: "1", Begin: day(24), End: day(31)}
+	tests := []struct {
+		name      string
+		member    TeamMember
+		wantBegin time.Time
+		wantEnd   time.Time
+		wantOk    bool
+	}{
+		{"whole competition", TeamMember{UserId: 1, Joined: day(1)}, day(24), day(31), true},
+		{"joined late", TeamMember{UserId: 1, Joined: day(26)}, day(26), day(31), true},
+		{"left early", TeamMember{UserId: 1, Left: day(28)}, day(24), day(28), true},
+		{"left before", TeamMember{UserId: 1, Left: day(20)}, day(24), day(20), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			begin, end, ok := c.MemberRange(tt.member)
+			if !begin.Equal(tt.wantBegin) || !end.Equal(tt.wantEnd) || ok != tt.wantOk {
+				t.Errorf("MemberRange() = %v, %v, %v, want %v, %v, %v", begin, end, ok, tt.wantBegin, tt.wantEnd, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestCompetitionSet_Default(t *testing.T) {
+	cs := DefaultCompetitions()
+	if got := cs.Default(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)); got != "2" {
+		t.Errorf("Default() = %s, want 2", got)
+	}
+	if got := cs.Default(day(25)); got != "1" {
+		t.Errorf("Default() = %s, want 1", got)
+	}
+}
+
+func TestCompetitionSet_Spanning(t *testing.T) {
+	cs := DefaultCompetitions()
+	if c, ok := cs.Spanning(day(24), day(31)); !ok || c.Id != "1" {
+		t.Errorf("Spanning() = %v, %v, want 1", c, ok)
+	}
+	if c, ok := cs.Spanning(day(24), day(30)); ok {
+		t.Errorf("Spanning() = %v, want none", c)
+	}
+}
+
+func TestNewTeamSummary(t *testing.T) {
+	team := NewTeamSummary("Red", []UserSummary{
+		{User: 1, Name: "Epi", Energy: 1000, TotalGains: 2000, TrainHappy: 5000, CrimeCounts: map[string]int{"theft": 2}},
+		{User: 2, Energy: 500, TotalGains: 1000, TrainHappy: 2000},
+		{User: 1, Energy: 500, TotalGains: 1000, TrainHappy: 5000, CrimeCounts: map[string]int{"theft": 1}},
+	})
+	if len(team.Members) != 2 || team.Members[0].Energy != 1500 || team.Members[0].CrimeCounts["theft"] != 3 {
+		t.Errorf("unexpected members: %+v", team.Members)
+	}
+	total := team.Total
+	if total.Name != "Red" || total.Energy != 2000 || total.TotalGains != 4000 || total.GainsPerKiloEnergy != 2000 ||
+		total.TrainHappy != 4250 || total.CrimeCounts["theft"] != 3 {
+		t.Errorf("unexpected total: %+v", total)
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// Deltas between stored snapshots are JSON merge patches (RFC 7386) of the document.
+// User.Diff drops what a snapshot needs to be rebuilt (names, maxima, unchanged stats),
+// whereas a merge patch round-trips the document exactly
+
+func decodeObject(b []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func createMergePatch(prev map[string]interface{}, curr map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key, value := range curr {
+		prevValue, ok := prev[key]
+		if ok && reflect.DeepEqual(prevValue, value) {
+			continue
+		}
+		prevObject, prevIsObject := prevValue.(map[string]interface{})
+		object, isObject := value.(map[string]interface{})
+		if ok && prevIsObject && isObject {
+			patch[key] = createMergePatch(prevObject, object)
+		} else {
+			patch[key] = value
+		}
+	}
+	for key := range prev {
+		if _, ok := curr[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+func applyMergePatch(doc map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(doc, key)
+		} else if object, isObject := value.(map[string]interface{}); isObject {
+			docObject, _ := doc[key].(map[string]interface{})
+			doc[key] = applyMergePatch(docObject, object)
+		} else {
+			doc[key] = value
+		}
+	}
+	return doc
+}
+
+func CreateMergePatch(prev []byte, curr []byte) ([]byte, error) {
+	prevObject, err := decodeObject(prev)
+	if err != nil {
+		return nil, err
+	}
+	currObject, err := decodeObject(curr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(createMergePatch(prevObject, currObject))
+}
+
+func ApplyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	docObject, err := decodeObject(doc)
+	if err != nil {
+		return nil, err
+	}
+	patchObject, err := decodeObject(patch)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(applyMergePatch(docObject, patchObject))
+}
+
+// Merge patch turning u into u2
+func (u User) Delta(u2 User) (string, error) {
+	prev, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	curr, err := json.Marshal(u2)
+	if err != nil {
+		return "", err
+	}
+	patch, err := CreateMergePatch(prev, curr)
+	return string(patch), err
+}
+
+func (u User) ApplyDelta(delta string) (User, error) {
+	var next User
+	if delta == "" {
+		return next, errors.New("empty delta")
+	}
+	doc, err := json.Marshal(u)
+	if err != nil {
+		return next, err
+	}
+	patched, err := ApplyMergePatch(doc, []byte(delta))
+	if err != nil {
+		return next, err
+	}
+	err = json.Unmarshal(patched, &next)
+	return next, err
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestUser_Delta(t *testing.T) {
+	var before User
+	var after User
+	if err := json.Unmarshal([]byte(BEFORE), &before); err != nil {
+		t.Fatalf("Unable to unmarshal BEFORE: %s", err)
+	}
+	if err := json.Unmarshal([]byte(AFTER), &after); err != nil {
+		t.Fatalf("Unable to unmarshal AFTER: %s", err)
+	}
+	// Dropped keys must be removed, not left over from the previous snapshot
+	delete(after.PersonalStats, StatLogins)
+	after.Items = nil
+
+	delta, err := before.Delta(after)
+	if err != nil {
+		t.Fatalf("Delta() = %v", err)
+	}
+	full, _ := json.Marshal(after)
+	if len(delta) >= len(full) {
+		t.Errorf("Delta() is %d bytes, full document is %d", len(delta), len(full))
+	}
+	got, err := before.ApplyDelta(delta)
+	if err != nil {
+		t.Fatalf("ApplyDelta() = %v", err)
+	}
+	if !reflect.DeepEqual(got, after) {
+		t.Errorf("ApplyDelta() = %+v, want %+v", got, after)
+	}
+}
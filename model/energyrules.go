@@ -0,0 +1,147 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Energy values of each source, effective from a point in time so historical diffs keep
+// the values Torn used when they happened
+type EnergyRules struct {
+	EffectiveFrom time.Time      `json:"effectiveFrom"`
+	Xanax         int            `json:"xanax"`
+	Lsd           int            `json:"lsd"`
+	Fhc           int            `json:"fhc"`
+	EnergyDrink   int            `json:"energyDrink"`  // cans that can't be matched to an item
+	EnergyDrinks  map[int]int    `json:"energyDrinks"` // energy by can item ID
+	AttackCost    int            `json:"attackCost"`
+	DumpCost      int            `json:"dumpCost"`
+	EdvdHappy     int            `json:"edvdHappy"`
+	BoosterSplit  BoosterSplit   `json:"boosterSplit"`
+	JobPoints     map[string]int `json:"jobPoints"` // energy per job point spent, by company
+}
+
+func DefaultEnergyRules() EnergyRules {
+	return EnergyRules{
+		Xanax:       250,
+		Lsd:         50,
+		Fhc:         150,
+		EnergyDrink: 30,
+		EnergyDrinks: map[int]int{
+			985: 5,  // Goose Juice
+			986: 10, // Damp Valley
+			987: 15, // Crocozade
+			530: 20, // Munster
+			553: 20, // Santa Shooters
+			532: 25, // Red Cow
+			554: 25, // Rockstar Rudolph
+			533: 30, // Taurine Elite
+			555: 30, // X-MASS
+		},
+		AttackCost: 25,
+		DumpCost:   5,
+		EdvdHappy:  2500,
+		JobPoints: map[string]int{
+			"Game Shop":       5,
+			"Candle Shop":     5,
+			"Farm":            7,
+			"Furniture Store": 3,
+			"Pub":             3,
+			"Restaurant":      3,
+		},
+	}
+}
+
+// How boosters are split into FHCs and eDVDs from the happy gained. The zero value is the
+// original heuristic, so rules without a split keep the results they always had
+type BoosterSplit struct {
+	FhcHappy int  `json:"fhcHappy,omitempty"` // happy assumed per booster, 400 when 0
+	Step     int  `json:"step,omitempty"`     // extra happy of an eDVD over an FHC, 2000 when 0
+	Round    bool `json:"round,omitempty"`    // to the nearest eDVD rather than down
+}
+
+func (b BoosterSplit) withDefaults() BoosterSplit {
+	if b.FhcHappy == 0 {
+		b.FhcHappy = 400
+	}
+	if b.Step == 0 {
+		b.Step = 2000
+	}
+	return b
+}
+
+// Sorted by EffectiveFrom
+type EnergyRuleSet []EnergyRules
+
+// Rules used by DiffAt; replaced at startup when a rules file is configured
+var ActiveEnergyRules = EnergyRuleSet{DefaultEnergyRules()}
+
+// Whether any rules version values the item as an energy drink
+func (rs EnergyRuleSet) IsEnergyDrink(itemId int) bool {
+	for _, r := range rs {
+		if _, ok := r.EnergyDrinks[itemId]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules in effect at t; times before the first entry use the first entry
+func (rs EnergyRuleSet) At(t time.Time) EnergyRules {
+	if len(rs) == 0 {
+		return DefaultEnergyRules()
+	}
+	rules := rs[0]
+	for _, r := range rs[1:] {
+		if r.EffectiveFrom.After(t) {
+			break
+		}
+		rules = r
+	}
+	return rules
+}
+
+func (rs EnergyRuleSet) Latest() EnergyRules {
+	if len(rs) == 0 {
+		return DefaultEnergyRules()
+	}
+	return rs[len(rs)-1]
+}
+
+func (rs EnergyRuleSet) Validate() error {
+	if len(rs) == 0 {
+		return errors.New("no energy rules defined")
+	}
+	for i, r := range rs {
+		if i > 0 && !r.EffectiveFrom.After(rs[i-1].EffectiveFrom) {
+			return fmt.Errorf("duplicate effectiveFrom: %s", r.EffectiveFrom)
+		}
+		if r.Xanax < 0 || r.Lsd < 0 || r.Fhc < 0 || r.EnergyDrink < 0 || r.AttackCost < 0 || r.DumpCost < 0 {
+			return fmt.Errorf("negative energy value in rules effective from %s", r.EffectiveFrom)
+		}
+		if r.BoosterSplit.FhcHappy < 0 || r.BoosterSplit.Step < 0 {
+			return fmt.Errorf("negative booster split in rules effective from %s", r.EffectiveFrom)
+		}
+	}
+	return nil
+}
+
+// Reads a JSON array of EnergyRules
+func LoadEnergyRuleSet(path string) (EnergyRuleSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rs EnergyRuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].EffectiveFrom.Before(rs[j].EffectiveFrom)
+	})
+	return rs, rs.Validate()
+}
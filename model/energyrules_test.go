@@ -0,0 +1,36 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnergyRuleSet_At(t *testing.T) {
+	changed := DefaultEnergyRules()
+	changed.EffectiveFrom = time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)
+	changed.Xanax = 300
+	rules := EnergyRuleSet{DefaultEnergyRules(), changed}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	before := User{BattleStats: BattleStats{Strength: "1", Speed: "1", Dexterity: "1", Defense: "1"}}
+	after := User{BattleStats: BattleStats{Strength: "2", Speed: "1", Dexterity: "1", Defense: "1"},
+		PersonalStats: PersonalStats{StatXanaxTaken: 1}}
+	old := ActiveEnergyRules
+	ActiveEnergyRules = rules
+	defer func() { ActiveEnergyRules = old }()
+	tests := []struct {
+		from time.Time
+		want int
+	}{
+		{time.Date(2019, time.August, 24, 0, 0, 0, 0, time.UTC), 250},
+		{time.Date(2019, time.September, 2, 0, 0, 0, 0, time.UTC), 300},
+	}
+	for _, tt := range tests {
+		diff := before.DiffAt(after, tt.from, tt.from)
+		if got := diff.CalculateEnergyTrained(); got != tt.want {
+			t.Errorf("CalculateEnergyTrained() from %s = %d, want %d", tt.from, got, tt.want)
+		}
+	}
+}
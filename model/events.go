@@ -0,0 +1,57 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	EventAttack       = "attack"
+	EventDump         = "dump"
+	EventLsd          = "lsd"
+	EventXanax        = "xanax"
+	EventOverdose     = "overdose"
+	EventRefill       = "refill"
+	EventBook         = "book"
+	EventEnergyDrink  = "energydrink"
+	EventConsumable   = "consumable"
+	EventJobPoints    = "jobpoints"
+	EventFhc          = "fhc"
+	EventEdvd         = "edvd"
+	EventTrain        = "train"
+	EventHappyJump    = "happyjump"
+	EventItemConsumed = "itemconsumed"
+	EventItemAcquired = "itemacquired"
+	EventCrime        = "crime"
+	EventNerveRefill  = "nerverefill"
+	EventBust         = "bust"
+	EventJailed       = "jailed"
+)
+
+type Event struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (e Event) String() string {
+	return e.Text
+}
+
+// Event seen in the diff between two snapshots
+type TimedEvent struct {
+	Event
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+var EventTypes = []string{EventAttack, EventDump, EventLsd, EventXanax, EventOverdose, EventRefill, EventBook,
+	EventEnergyDrink, EventConsumable, EventJobPoints, EventFhc, EventEdvd, EventTrain, EventHappyJump,
+	EventItemConsumed, EventItemAcquired, EventCrime, EventNerveRefill, EventBust, EventJailed}
+
+func IsEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
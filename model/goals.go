@@ -0,0 +1,126 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	GoalEnergy = "energy" // energy trained between Begin and End
+	GoalStat   = "stat"   // a battle stat reaching Target by End
+)
+
+const (
+	GoalStrength  = "strength"
+	GoalSpeed     = "speed"
+	GoalDexterity = "dexterity"
+	GoalDefense   = "defense"
+	GoalTotal     = "total"
+)
+
+// Rates used for projections are taken over this much recent history
+const GoalRateWindow = time.Hour * 24 * 7
+
+// Projections further out than this are left empty; they'd overflow time.Duration long
+// before they mean anything
+const GoalMaxProjection = time.Hour * 24 * 365 * 10
+
+type Goal struct {
+	Id       string     `json:"id,omitempty"` // assigned on insert
+	UserId   uint       `json:"userId"`
+	Type     string     `json:"type"`
+	Stat     string     `json:"stat,omitempty"` // for stat goals
+	Target   float64    `json:"target"`
+	Begin    time.Time  `json:"begin"`
+	End      time.Time  `json:"end"`
+	Achieved *time.Time `json:"achieved,omitempty"` // set once the goal is hit and notified
+}
+
+func (g Goal) Validate() error {
+	if g.UserId == 0 {
+		return errors.New("missing userId")
+	}
+	switch g.Type {
+	case GoalEnergy:
+	case GoalStat:
+		switch g.Stat {
+		case GoalStrength, GoalSpeed, GoalDexterity, GoalDefense, GoalTotal:
+		default:
+			return errors.New("invalid stat, expected one of: [strength, speed, dexterity, defense, total]")
+		}
+	default:
+		return errors.New("invalid type, expected one of: [energy, stat]")
+	}
+	if g.Target <= 0 {
+		return errors.New("target must be positive")
+	}
+	if !g.End.After(g.Begin) {
+		return errors.New("end must be after begin")
+	}
+	return nil
+}
+
+func (g Goal) Describe() string {
+	if g.Type == GoalStat {
+		return fmt.Sprintf("reach %.0f %s by %s", g.Target, g.Stat, g.End.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("train %.0fe by %s", g.Target, g.End.Format("2006-01-02"))
+}
+
+func StatValue(bs BattleStats, stat string) float64 {
+	var value string
+	switch stat {
+	case GoalStrength:
+		value = bs.Strength
+	case GoalSpeed:
+		value = bs.Speed
+	case GoalDexterity:
+		value = bs.Dexterity
+	case GoalDefense:
+		value = bs.Defense
+	default:
+		return bs.Total()
+	}
+	v, _ := ToFloat(value).Float64()
+	return v
+}
+
+type GoalProgress struct {
+	Goal      Goal      `json:"goal"`
+	Start     float64   `json:"start"`   // stat at the first snapshot; 0 for energy goals
+	Current   float64   `json:"current"` // energy trained so far, or the latest stat
+	Percent   float64   `json:"percent"`
+	Rate      float64   `json:"rate"`                // per day over GoalRateWindow
+	Projected time.Time `json:"projected,omitempty"` // when Target is reached at Rate
+	OnTrack   bool      `json:"onTrack"`
+	Done      bool      `json:"done"`
+}
+
+// Fills in percent, projection and whether the goal is done or on track
+func NewGoalProgress(goal Goal, start float64, current float64, rate float64, now time.Time) GoalProgress {
+	p := GoalProgress{Goal: goal, Start: start, Current: current, Rate: rate}
+	gained, needed := current, goal.Target
+	if goal.Type == GoalStat {
+		gained, needed = current-start, goal.Target-start
+	}
+	if needed <= 0 || current >= goal.Target {
+		p.Percent = 100
+		p.Done = true
+		p.OnTrack = true
+		p.Projected = now
+		return p
+	}
+	p.Percent = 100 * gained / needed
+	if p.Percent < 0 {
+		p.Percent = 0
+	}
+	if rate > 0 {
+		remaining := (goal.Target - current) / rate * float64(time.Hour*24)
+		if remaining <= float64(GoalMaxProjection) {
+			p.Projected = now.Add(time.Duration(remaining)).Truncate(time.Minute)
+			p.OnTrack = !p.Projected.After(goal.End)
+		}
+	}
+	return p
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewGoalProgress(t *testing.T) {
+	begin := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(time.Hour * 24 * 30)
+	now := begin.Add(time.Hour * 24 * 10)
+	energy := Goal{UserId: 1, Type: GoalEnergy, Target: 50000, Begin: begin, End: end}
+	speed := Goal{UserId: 1, Type: GoalStat, Stat: GoalSpeed, Target: 1000000, Begin: begin, End: end}
+	tests := []struct {
+		name      string
+		goal      Goal
+		start     float64
+		current   float64
+		rate      float64
+		percent   float64
+		projected time.Time
+		onTrack   bool
+		done      bool
+	}{
+		{"energy on track", energy, 0, 20000, 2000, 40, now.Add(time.Hour * 24 * 15), true, false},
+		{"energy behind", energy, 0, 20000, 1000, 40, now.Add(time.Hour * 24 * 30), false, false},
+		{"energy no rate", energy, 0, 20000, 0, 40, time.Time{}, false, false},
+		{"energy hopeless", Goal{UserId: 1, Type: GoalEnergy, Target: 1e9, Begin: begin, End: end}, 0, 1e5, 1, 0.01, time.Time{}, false, false},
+		{"energy done", energy, 0, 50500, 1000, 100, now, true, true},
+		{"stat relative to start", speed, 600000, 700000, 20000, 25, now.Add(time.Hour * 24 * 15), true, false},
+		{"stat already past target", speed, 1200000, 1200000, 0, 100, now, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.goal.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			p := NewGoalProgress(tt.goal, tt.start, tt.current, tt.rate, now)
+			if p.Percent != tt.percent {
+				t.Errorf("Percent = %v, want %v", p.Percent, tt.percent)
+			}
+			if !p.Projected.Equal(tt.projected) {
+				t.Errorf("Projected = %v, want %v", p.Projected, tt.projected)
+			}
+			if p.OnTrack != tt.onTrack || p.Done != tt.done {
+				t.Errorf("OnTrack, Done = %v, %v, want %v, %v", p.OnTrack, p.Done, tt.onTrack, tt.done)
+			}
+		})
+	}
+}
+
+func TestGoal_Validate(t *testing.T) {
+	begin := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(time.Hour * 24)
+	invalid := []Goal{
+		{Type: GoalEnergy, Target: 1, Begin: begin, End: end},
+		{UserId: 1, Type: "xanax", Target: 1, Begin: begin, End: end},
+		{UserId: 1, Type: GoalStat, Stat: "luck", Target: 1, Begin: begin, End: end},
+		{UserId: 1, Type: GoalEnergy, Target: 0, Begin: begin, End: end},
+		{UserId: 1, Type: GoalEnergy, Target: 1, Begin: end, End: begin},
+	}
+	for _, goal := range invalid {
+		if err := goal.Validate(); err == nil {
+			t.Errorf("Validate() expected an error for %+v", goal)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Happy a train has to start from to count towards a jump
+const HappyJumpMinHappy = 20000
+
+// Energy a jump has to train in total
+const HappyJumpMinEnergy = 500
+
+type HappyJump struct {
+	UserId    uint      `json:"userId"`
+	Name      string    `json:"name,omitempty"`
+	Started   time.Time `json:"started"` // first stacking snapshot
+	Trained   time.Time `json:"trained"` // first train at jump happy
+	Ended     time.Time `json:"ended"`   // happy reset
+	EDVDs     int       `json:"edvds"`
+	Ecstasy   int       `json:"ecstasy"`
+	Xanax     int       `json:"xanax"`
+	PeakHappy int       `json:"peakHappy"`
+	Energy    int       `json:"energy"`
+	Gains     string    `json:"gains"`
+}
+
+func (hj HappyJump) Event() Event {
+	return Event{EventHappyJump, fmt.Sprintf("completed a happy jump: trained %de at %d happy gaining %s stats (%d eDVDs, %d ecstasy, %d xanax)",
+		hj.Energy, hj.PeakHappy, hj.Gains, hj.EDVDs, hj.Ecstasy, hj.Xanax)}
+}
+
+// Tracks one user's diffs through stacking -> training -> happy reset
+type HappyJumpDetector struct {
+	jump     *HappyJump
+	training bool
+	gains    *big.Float
+}
+
+func (d *HappyJumpDetector) reset() {
+	d.jump = nil
+	d.training = false
+	d.gains = nil
+}
+
+// Feeds the next diff (between snapshots taken at from and to), returning a jump once it completes
+func (d *HappyJumpDetector) Next(u UserDiff, from time.Time, to time.Time) *HappyJump {
+	_, edvd := CalculateBoosterSplit(u.EnergyRules(), u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken(),
+		u.PersonalStats.BoostersUsed(), u.PersonalStats.Overdosed(), u.Bars.Energy.Current, u.IsTrain())
+	stacking := edvd > 0 || u.PersonalStats.EcstasyTaken() > 0 || u.PersonalStats.XanaxTaken() > 0
+
+	if d.jump == nil {
+		if !stacking || u.IsTrain() {
+			return nil
+		}
+		d.jump = &HappyJump{UserId: u.UserId, Started: from}
+		d.gains = new(big.Float).SetPrec(prec)
+	}
+	d.jump.EDVDs += edvd
+	d.jump.Ecstasy += u.PersonalStats.EcstasyTaken()
+	d.jump.Xanax += u.PersonalStats.XanaxTaken()
+	if u.Bars.Happy.Previous > d.jump.PeakHappy {
+		d.jump.PeakHappy = u.Bars.Happy.Previous
+	}
+
+	if u.IsTrain() {
+		if !d.training && u.Bars.Happy.Previous < HappyJumpMinHappy {
+			// Ordinary train, the stack was abandoned
+			d.reset()
+			return nil
+		}
+		if !d.training {
+			d.training = true
+			d.jump.Trained = from
+		}
+		d.jump.Energy += u.CalculateEnergyTrained()
+		d.gains.Add(d.gains, u.BattleStats.GetTotalGains())
+	}
+
+	if d.training && u.Bars.Happy.Current < HappyJumpMinHappy {
+		jump := *d.jump
+		jump.Ended = to
+		jump.Gains = d.gains.Text('f', 4)
+		d.reset()
+		if jump.Energy < HappyJumpMinEnergy || jump.EDVDs+jump.Ecstasy == 0 {
+			return nil
+		}
+		return &jump
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func happyDiff(prevHappy int, currHappy int, ps PersonalStats) UserDiff {
+	var diff UserDiff
+	diff.Bars.Happy = Happy{Previous: prevHappy, Current: currHappy}
+	diff.PersonalStats = ps
+	return diff
+}
+
+func TestHappyJumpDetector_Next(t *testing.T) {
+	train := happyDiff(24400, 23000, PersonalStats{})
+	train.BattleStats.Strength = "1000.0000"
+	train.Bars.Energy = Energy{Previous: 1000, Current: 0}
+	diffs := []UserDiff{
+		happyDiff(5000, 12200, PersonalStats{StatBoostersUsed: 3}),
+		happyDiff(12200, 24400, PersonalStats{StatEcstasyTaken: 1, StatXanaxTaken: 1}),
+		train,
+		happyDiff(23000, 5000, PersonalStats{}),
+	}
+	start := time.Date(2019, time.August, 25, 0, 0, 0, 0, time.UTC)
+	var detector HappyJumpDetector
+	var jumps []HappyJump
+	for i, diff := range diffs {
+		from := start.Add(time.Duration(i) * time.Minute * 5)
+		if jump := detector.Next(diff, from, from.Add(time.Minute*5)); jump != nil {
+			jumps = append(jumps, *jump)
+		}
+	}
+	if len(jumps) != 1 {
+		t.Fatalf("Next() got %d jumps, want 1", len(jumps))
+	}
+	jump := jumps[0]
+	if jump.EDVDs != 3 || jump.Ecstasy != 1 || jump.Xanax != 1 || jump.Energy != 1000 || jump.PeakHappy != 24400 || jump.Gains != "1000.0000" {
+		t.Errorf("Next() jump = %+v", jump)
+	}
+	if !jump.Started.Equal(start) || !jump.Ended.Equal(start.Add(time.Minute*20)) {
+		t.Errorf("Next() jump window = %s - %s", jump.Started, jump.Ended)
+	}
+
+	// A plain train after xanax isn't a jump
+	detector = HappyJumpDetector{}
+	plain := happyDiff(5000, 4900, PersonalStats{})
+	plain.BattleStats.Strength = "10.0000"
+	plain.Bars.Energy = Energy{Previous: 400, Current: 0}
+	for _, diff := range []UserDiff{happyDiff(5000, 5000, PersonalStats{StatXanaxTaken: 1}), plain} {
+		if jump := detector.Next(diff, start, start); jump != nil {
+			t.Errorf("Next() = %+v, want no jump", jump)
+		}
+	}
+}
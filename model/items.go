@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Inventory items kept on each User, by item ID; every item is kept when empty
+var ItemAllowlist map[int]bool
+
+func (i Item) DisplayName() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	return fmt.Sprintf("item #%d", i.Id)
+}
+
+// Inventory decreases are reported as consumed and increases as acquired; Torn doesn't say
+// whether an item was used, sold or given away, nor whether it was bought or received
+func (u UserDiff) GetItemEvents() []Event {
+	var events []Event
+	for _, item := range u.Items {
+		if item.Quantity < 0 {
+			events = append(events, Event{EventItemConsumed, fmt.Sprintf("consumed %dx %s", -1*item.Quantity, item.DisplayName())})
+		} else if item.Quantity > 0 {
+			events = append(events, Event{EventItemAcquired, fmt.Sprintf("acquired %dx %s", item.Quantity, item.DisplayName())})
+		}
+	}
+	return events
+}
+
+type ItemLedgerEntry struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	ItemId   int       `json:"itemId"`
+	Name     string    `json:"name,omitempty"`
+	Quantity int       `json:"quantity"` // negative when consumed
+}
+
+type ItemTotal struct {
+	ItemId   int    `json:"itemId"`
+	Name     string `json:"name,omitempty"`
+	Consumed int    `json:"consumed"`
+	Acquired int    `json:"acquired"`
+}
+
+type ItemLedger struct {
+	UserId  uint              `json:"userId"`
+	Name    string            `json:"name,omitempty"`
+	Entries []ItemLedgerEntry `json:"entries"`
+	Totals  []ItemTotal       `json:"totals"`
+}
+
+// Builds a ledger from the item diffs of consecutive snapshots
+func NewItemLedger(userId uint) *ItemLedger {
+	return &ItemLedger{UserId: userId, Entries: []ItemLedgerEntry{}, Totals: []ItemTotal{}}
+}
+
+func (l *ItemLedger) Add(diff UserDiff, from time.Time, to time.Time) {
+	for _, item := range diff.Items {
+		if item.Quantity == 0 {
+			continue
+		}
+		l.Entries = append(l.Entries, ItemLedgerEntry{from, to, item.Id, item.Name, item.Quantity})
+		i := sort.Search(len(l.Totals), func(i int) bool {
+			return l.Totals[i].ItemId >= item.Id
+		})
+		if i == len(l.Totals) || l.Totals[i].ItemId != item.Id {
+			l.Totals = append(l.Totals, ItemTotal{})
+			copy(l.Totals[i+1:], l.Totals[i:])
+			l.Totals[i] = ItemTotal{ItemId: item.Id, Name: item.Name}
+		}
+		if item.Quantity < 0 {
+			l.Totals[i].Consumed += -1 * item.Quantity
+		} else {
+			l.Totals[i].Acquired += item.Quantity
+		}
+	}
+}
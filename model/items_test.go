@@ -0,0 +1,38 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestItemLedger_Add(t *testing.T) {
+	before := []Item{{Id: 206, Name: "Xanax", Quantity: 5}, {Id: FHC, Name: "Feathery Hotel Coupon", Quantity: 1}}
+	after := []Item{{Id: 206, Name: "Xanax", Quantity: 3}, {Id: 180, Name: "Bottle of Beer", Quantity: 10}}
+	diff := UserDiff{User: User{Items: CalculateItemDiffs(before, after)}}
+	events := diff.GetItemEvents()
+	want := []Event{
+		{EventItemAcquired, "acquired 10x Bottle of Beer"},
+		{EventItemConsumed, "consumed 2x Xanax"},
+		{EventItemConsumed, "consumed 1x Feathery Hotel Coupon"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("GetItemEvents() = %v, want %v", events, want)
+	}
+
+	ledger := NewItemLedger(1)
+	start := time.Date(2019, time.August, 24, 0, 0, 0, 0, time.UTC)
+	ledger.Add(diff, start, start.Add(time.Minute))
+	ledger.Add(UserDiff{User: User{Items: CalculateItemDiffs(after, before)}}, start.Add(time.Minute), start.Add(time.Minute*2))
+	if len(ledger.Entries) != 6 {
+		t.Errorf("Entries = %v, want 6", ledger.Entries)
+	}
+	wantTotals := []ItemTotal{
+		{180, "Bottle of Beer", 10, 10},
+		{206, "Xanax", 2, 2},
+		{FHC, "Feathery Hotel Coupon", 1, 1},
+	}
+	if !reflect.DeepEqual(ledger.Totals, wantTotals) {
+		t.Errorf("Totals = %v, want %v", ledger.Totals, wantTotals)
+	}
+}
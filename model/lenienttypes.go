@@ -9,7 +9,7 @@ type Bool struct {
 	Value bool
 }
 
-func (v Bool) MarshalJson() ([]byte, error) {
+func (v Bool) MarshalJSON() ([]byte, error) {
 	return json.Marshal(v.Value)
 }
 
@@ -45,7 +45,12 @@ func (v Float32) String() string {
 	return v.Value
 }
 
-func (v Float32) MarshalJson() ([]byte, error) {
+func (v Float32) Float64() float64 {
+	f, _ := strconv.ParseFloat(v.Value, 64)
+	return f
+}
+
+func (v Float32) MarshalJSON() ([]byte, error) {
 	return json.Marshal(v.Value)
 }
 
@@ -55,9 +60,9 @@ func (v *Float32) UnmarshalJSON(b []byte) error {
 		*v = Float32{Value : s}
 		return nil
 	}
-	var i int64
-	if err := json.Unmarshal(b, &i); err == nil {
-		*v = Float32{Value: strconv.FormatInt(i, 10)}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err == nil {
+		*v = Float32{Value: n.String()}
 	}
 	return nil
 }
@@ -1,89 +1,216 @@
 package model
 
-type PersonalStats struct {
-	AttacksWon int `json:"attackswon,omitempty"`
-	DumpSearches int `json:"dumpsearches,omitempty"`
-	UserActivity int `json:"useractivity,omitempty"`
-	Logins int `json:"logins,omitempty"`
-	AttacksLost int `json:"attackslost,omitempty"`
-	XanaxTaken int `json:"xantaken,omitempty"`
-	AttacksDraw int `json:"attacksdraw,omitempty"`
-	LsdTaken int `json:"lsdtaken,omitempty"`
-	EcstasyTaken int `json:"exttaken,omitempty"`
-	Overdosed int `json:"overdosed,omitempty"`
-	YouRunAway int `json:"yourunaway,omitempty"`
-	AttacksAssisted int `json:"attacksassisted,omitempty"`
-	CannabisTaken int `json:"cantaken,omitempty"`
-	ConsumablesUsed int `json:"consumablesused,omitempty"`
-	CandyUsed int `json:"candyused,omitempty"`
-	AlcoholUsed int `json:"alcoholused,omitempty"`
-	EnergyDrinkUsed int `json:"energydrinkused,omitempty"`
-	BooksRead int `json:"booksread,omitempty"`
-	NerveRefills int `json:"nerverefills,omitempty"`
-	BoostersUsed int `json:"boostersused,omitempty"`
-	Refills int `json:"refills,omitempty"`
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Every personal stat returned by the API, keyed by its Torn name, so snapshots keep stats
+// nothing reads yet
+type PersonalStats map[string]int64
+
+// Keys of the stats read by the energy, nerve and crime logic
+const (
+	StatAttacksWon             = "attackswon"
+	StatDumpSearches           = "dumpsearches"
+	StatUserActivity           = "useractivity"
+	StatLogins                 = "logins"
+	StatAttacksLost            = "attackslost"
+	StatXanaxTaken             = "xantaken"
+	StatAttacksDraw            = "attacksdraw"
+	StatLsdTaken               = "lsdtaken"
+	StatEcstasyTaken           = "exttaken"
+	StatOverdosed              = "overdosed"
+	StatYouRunAway             = "yourunaway"
+	StatAttacksAssisted        = "attacksassisted"
+	StatCannabisTaken          = "cantaken"
+	StatConsumablesUsed        = "consumablesused"
+	StatCandyUsed              = "candyused"
+	StatAlcoholUsed            = "alcoholused"
+	StatEnergyDrinkUsed        = "energydrinkused"
+	StatBooksRead              = "booksread"
+	StatNerveRefills           = "nerverefills"
+	StatBoostersUsed           = "boostersused"
+	StatRefills                = "refills"
+	StatCriminalOffenses       = "criminaloffenses"
+	StatSellingIllegalProducts = "sellingillegalproducts"
+	StatTheft                  = "theft"
+	StatAutoTheft              = "autotheft"
+	StatDrugDeals              = "drugdeals"
+	StatComputerCrimes         = "computercrimes"
+	StatFraudCrimes            = "fraudcrimes"
+	StatMurder                 = "murder"
+	StatOtherCrimes            = "othercrimes"
+	StatOrganisedCrimes        = "organisedcrimes"
+	StatPeopleBusted           = "peoplebusted"
+	StatFailedBusts            = "failedbusts"
+	StatJailed                 = "jailed"
+)
+
+// The stats above; only changes to these are worth a new snapshot, as others like networth
+// move on almost every poll
+var TrackedStats = []string{
+	StatAttacksWon, StatDumpSearches, StatUserActivity, StatLogins, StatAttacksLost, StatXanaxTaken,
+	StatAttacksDraw, StatLsdTaken, StatEcstasyTaken, StatOverdosed, StatYouRunAway, StatAttacksAssisted,
+	StatCannabisTaken, StatConsumablesUsed, StatCandyUsed, StatAlcoholUsed, StatEnergyDrinkUsed,
+	StatBooksRead, StatNerveRefills, StatBoostersUsed, StatRefills, StatCriminalOffenses,
+	StatSellingIllegalProducts, StatTheft, StatAutoTheft, StatDrugDeals, StatComputerCrimes,
+	StatFraudCrimes, StatMurder, StatOtherCrimes, StatOrganisedCrimes, StatPeopleBusted,
+	StatFailedBusts, StatJailed,
 }
 
+// Whether the tracked stats match; untracked keys are ignored
+func (ps PersonalStats) Equals(ps2 PersonalStats) bool {
+	for _, key := range TrackedStats {
+		if ps[key] != ps2[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ps PersonalStats) Get(key string) int {
+	return int(ps[key])
+}
+
+func (ps PersonalStats) AttacksWon() int             { return ps.Get(StatAttacksWon) }
+func (ps PersonalStats) DumpSearches() int           { return ps.Get(StatDumpSearches) }
+func (ps PersonalStats) UserActivity() int           { return ps.Get(StatUserActivity) }
+func (ps PersonalStats) Logins() int                 { return ps.Get(StatLogins) }
+func (ps PersonalStats) AttacksLost() int            { return ps.Get(StatAttacksLost) }
+func (ps PersonalStats) XanaxTaken() int             { return ps.Get(StatXanaxTaken) }
+func (ps PersonalStats) AttacksDraw() int            { return ps.Get(StatAttacksDraw) }
+func (ps PersonalStats) LsdTaken() int               { return ps.Get(StatLsdTaken) }
+func (ps PersonalStats) EcstasyTaken() int           { return ps.Get(StatEcstasyTaken) }
+func (ps PersonalStats) Overdosed() int              { return ps.Get(StatOverdosed) }
+func (ps PersonalStats) YouRunAway() int             { return ps.Get(StatYouRunAway) }
+func (ps PersonalStats) AttacksAssisted() int        { return ps.Get(StatAttacksAssisted) }
+func (ps PersonalStats) CannabisTaken() int          { return ps.Get(StatCannabisTaken) }
+func (ps PersonalStats) ConsumablesUsed() int        { return ps.Get(StatConsumablesUsed) }
+func (ps PersonalStats) CandyUsed() int              { return ps.Get(StatCandyUsed) }
+func (ps PersonalStats) AlcoholUsed() int            { return ps.Get(StatAlcoholUsed) }
+func (ps PersonalStats) EnergyDrinkUsed() int        { return ps.Get(StatEnergyDrinkUsed) }
+func (ps PersonalStats) BooksRead() int              { return ps.Get(StatBooksRead) }
+func (ps PersonalStats) NerveRefills() int           { return ps.Get(StatNerveRefills) }
+func (ps PersonalStats) BoostersUsed() int           { return ps.Get(StatBoostersUsed) }
+func (ps PersonalStats) Refills() int                { return ps.Get(StatRefills) }
+func (ps PersonalStats) CriminalOffenses() int       { return ps.Get(StatCriminalOffenses) }
+func (ps PersonalStats) SellingIllegalProducts() int { return ps.Get(StatSellingIllegalProducts) }
+func (ps PersonalStats) Theft() int                  { return ps.Get(StatTheft) }
+func (ps PersonalStats) AutoTheft() int              { return ps.Get(StatAutoTheft) }
+func (ps PersonalStats) DrugDeals() int              { return ps.Get(StatDrugDeals) }
+func (ps PersonalStats) ComputerCrimes() int         { return ps.Get(StatComputerCrimes) }
+func (ps PersonalStats) FraudCrimes() int            { return ps.Get(StatFraudCrimes) }
+func (ps PersonalStats) Murder() int                 { return ps.Get(StatMurder) }
+func (ps PersonalStats) OtherCrimes() int            { return ps.Get(StatOtherCrimes) }
+func (ps PersonalStats) OrganisedCrimes() int        { return ps.Get(StatOrganisedCrimes) }
+func (ps PersonalStats) PeopleBusted() int           { return ps.Get(StatPeopleBusted) }
+func (ps PersonalStats) FailedBusts() int            { return ps.Get(StatFailedBusts) }
+func (ps PersonalStats) Jailed() int                 { return ps.Get(StatJailed) }
+
+// Accepts any JSON number; fractional stats are truncated and non-numeric values skipped
+func (ps *PersonalStats) UnmarshalJSON(b []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	stats := make(PersonalStats, len(raw))
+	for key, value := range raw {
+		number, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := number.Int64(); err == nil {
+			stats[key] = i
+		} else if f, err := strconv.ParseFloat(string(number), 64); err == nil {
+			stats[key] = int64(f)
+		}
+	}
+	*ps = stats
+	return nil
+}
+
+// Changes across every key in either snapshot; unchanged keys are left out
 func (ps PersonalStats) Diff(ps2 PersonalStats) PersonalStats {
-	var diff PersonalStats
-	diff.AttacksWon = ps2.AttacksWon - ps.AttacksWon
-	diff.DumpSearches = ps2.DumpSearches - ps.DumpSearches
-	diff.UserActivity = ps2.UserActivity - ps.UserActivity
-	diff.Logins = ps2.Logins - ps.Logins
-	diff.AttacksLost = ps2.AttacksLost - ps.AttacksLost
-	diff.XanaxTaken = ps2.XanaxTaken - ps.XanaxTaken
-	diff.AttacksDraw = ps2.AttacksDraw - ps.AttacksDraw
-	diff.LsdTaken = ps2.LsdTaken - ps.LsdTaken
-	diff.EcstasyTaken = ps2.EcstasyTaken - ps.EcstasyTaken
-	diff.Overdosed = ps2.Overdosed - ps.Overdosed
-	diff.YouRunAway = ps2.YouRunAway - ps.YouRunAway
-	diff.AttacksAssisted = ps2.AttacksAssisted - ps.AttacksAssisted
-	diff.CannabisTaken = ps2.CannabisTaken - ps.CannabisTaken
-	diff.ConsumablesUsed = ps2.ConsumablesUsed - ps.ConsumablesUsed
-	diff.CandyUsed = ps2.CandyUsed - ps.CandyUsed
-	diff.AlcoholUsed = ps2.AlcoholUsed - ps.AlcoholUsed
-	diff.EnergyDrinkUsed = ps2.EnergyDrinkUsed - ps.EnergyDrinkUsed
-	diff.BooksRead = ps2.BooksRead - ps.BooksRead
-	diff.NerveRefills = ps2.NerveRefills - ps.NerveRefills
-	diff.BoostersUsed = ps2.BoostersUsed - ps.BoostersUsed
-	diff.Refills = ps2.Refills - ps.Refills
+	diff := make(PersonalStats)
+	for key, value := range ps2 {
+		if delta := value - ps[key]; delta != 0 {
+			diff[key] = delta
+		}
+	}
+	for key, value := range ps {
+		if _, ok := ps2[key]; !ok && value != 0 {
+			diff[key] = -value
+		}
+	}
 	return diff
 }
 
+// Crimes committed by category, in the order Torn lists them; empty categories are omitted
+func (ps PersonalStats) CrimeCounts() []CrimeCount {
+	categories := []CrimeCount{
+		{"selling", ps.SellingIllegalProducts()},
+		{"theft", ps.Theft()},
+		{"autotheft", ps.AutoTheft()},
+		{"drugs", ps.DrugDeals()},
+		{"computer", ps.ComputerCrimes()},
+		{"fraud", ps.FraudCrimes()},
+		{"murder", ps.Murder()},
+		{"other", ps.OtherCrimes()},
+		{"oc", ps.OrganisedCrimes()},
+	}
+	var counts []CrimeCount
+	for _, c := range categories {
+		if c.Count > 0 {
+			counts = append(counts, c)
+		}
+	}
+	return counts
+}
+
+type CrimeCount struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+func (c CrimeCount) String() string {
+	return fmt.Sprintf("%s=%d", c.Category, c.Count)
+}
+
 func (ps PersonalStats) IsDiffAttack() bool {
-	return ps.AttacksWon > 0 || ps.AttacksLost > 0 || ps.AttacksDraw > 0 || ps.AttacksAssisted > 0 || ps.YouRunAway > 0
+	return ps.AttacksWon() > 0 || ps.AttacksLost() > 0 || ps.AttacksDraw() > 0 || ps.AttacksAssisted() > 0 || ps.YouRunAway() > 0
 }
 
-func (ps PersonalStats) GetEvents() []string {
-	var events []string
+func (ps PersonalStats) GetEvents(rules EnergyRules) []Event {
+	var events []Event
 	if ps.IsDiffAttack() {
-		events = append(events, "wasted 25e by attacking someone")
-	}
-	if ps.DumpSearches > 0 {
-		events = append(events, "wasted 5e by searching the dump")
-	}
-	if ps.LsdTaken > 0 {
-		events = append(events, "gained 50e by taking LSD")
+		events = append(events, Event{EventAttack, fmt.Sprintf("wasted %de by attacking someone", rules.AttackCost)})
 	}
-	if ps.XanaxTaken > 0 {
-		events = append(events, "gained 250e by taking Xanax")
+	if ps.DumpSearches() > 0 {
+		events = append(events, Event{EventDump, fmt.Sprintf("wasted %de by searching the dump", rules.DumpCost)})
 	}
-	if ps.Overdosed > 0 {
-		events = append(events, "overdosed, RIP")
+	if ps.LsdTaken() > 0 {
+		events = append(events, Event{EventLsd, fmt.Sprintf("gained %de by taking LSD", rules.Lsd)})
 	}
-	if ps.Refills > 0 {
-		events = append(events, "gained 150e* by using a point refill")
+	if ps.XanaxTaken() > 0 {
+		events = append(events, Event{EventXanax, fmt.Sprintf("gained %de by taking Xanax", rules.Xanax)})
 	}
-	if ps.BooksRead > 0 {
-		events = append(events, "read a book")
+	if ps.Overdosed() > 0 {
+		events = append(events, Event{EventOverdose, "overdosed, RIP"})
 	}
-	if ps.EnergyDrinkUsed > 0 {
-		events = append(events, "gained 30e* by consuming an energy drink")
+	// Refills depend on max energy and the refills selection; determined at UserDiff level
+	if ps.BooksRead() > 0 {
+		events = append(events, Event{EventBook, "read a book"})
 	}
+	// Energy drinks depend on inventory to value; determined at UserDiff level
 	// Booster can be FHCs or EDVDs; can guesstimate based on User data; determine at that level
-	// if ps.BoostersUsed > 0 {}
-	if ps.ConsumablesUsed > 0 {
-		events = append(events, "ate ass")
+	// if ps.BoostersUsed() > 0 {}
+	if ps.ConsumablesUsed() > 0 {
+		events = append(events, Event{EventConsumable, "ate ass"})
 	}
 	return events
 }
@@ -93,32 +220,35 @@ func (ps PersonalStats) IsDiffRelevant() []string {
 	if ps.IsDiffAttack() {
 		reasons = append(reasons, "attack")
 	}
-	if ps.DumpSearches > 0 {
+	if ps.DumpSearches() > 0 {
 		reasons = append(reasons, "dump")
 	}
-	if ps.LsdTaken > 0 {
+	if ps.LsdTaken() > 0 {
 		reasons = append(reasons, "lsd")
 	}
-	if ps.XanaxTaken > 0 {
+	if ps.XanaxTaken() > 0 {
 		reasons = append(reasons, "xanax")
 	}
-	if ps.Overdosed > 0 {
+	if ps.Overdosed() > 0 {
 		reasons = append(reasons, "od")
 	}
-	if ps.Refills > 0 {
+	if ps.Refills() > 0 {
 		reasons = append(reasons, "psprf")
 	}
-	if ps.BooksRead > 0 {
+	if ps.BooksRead() > 0 {
 		reasons = append(reasons, "book")
 	}
-	if ps.CannabisTaken > 0 || ps.EnergyDrinkUsed > 0 {
+	if ps.CannabisTaken() > 0 || ps.EnergyDrinkUsed() > 0 {
 		reasons = append(reasons, "energydrink")
 	}
-	if ps.BoostersUsed > 0 {
+	if ps.BoostersUsed() > 0 {
 		reasons = append(reasons, "booster")
 	}
-	if ps.ConsumablesUsed > 0 {
+	if ps.ConsumablesUsed() > 0 {
 		reasons = append(reasons, "consumable")
 	}
+	if ps.CriminalOffenses() > 0 || ps.PeopleBusted() > 0 || ps.FailedBusts() > 0 {
+		reasons = append(reasons, "crime")
+	}
 	return reasons
-}
\ No newline at end of file
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Refills struct {
 	EnergyRefillUsed bool `json:"energy_refill_used,omitempty"`
 	SpecialRefillsAvailable int `json:"special_refills_available,omitempty"`
@@ -12,4 +14,105 @@ func (r Refills) Diff(r2 Refills) Refills {
 	// _Decreases_ when used, i.e. negative diff means special refill was used
 	diff.SpecialRefillsAvailable = r2.SpecialRefillsAvailable - r.SpecialRefillsAvailable
 	return diff
-}
\ No newline at end of file
+}
+type RefillUsage struct {
+	Daily   int `json:"daily"`   // daily energy refill, from the refills selection
+	Special int `json:"special"` // special refills, from SpecialRefillsAvailable decreasing
+	Total   int `json:"total"`
+}
+
+func (u UserDiff) selectionRefills() RefillUsage {
+	var usage RefillUsage
+	if u.Refills.EnergyRefillUsed {
+		usage.Daily = 1
+	}
+	if u.Refills.SpecialRefillsAvailable < 0 {
+		usage.Special = -1 * u.Refills.SpecialRefillsAvailable
+	}
+	usage.Total = usage.Daily + usage.Special
+	return usage
+}
+
+// Reconciles PersonalStats.Refills() with the refills selection. Each source can miss refills
+// (the daily flag resets at midnight, special refills can be bought in the same interval),
+// so the larger of the two counts is used rather than their sum. Diffs reconciled by a
+// RefillReconciler also account for the sources updating in different snapshots
+func (u UserDiff) CalculateRefills() RefillUsage {
+	if u.ReconciledRefills != nil {
+		return *u.ReconciledRefills
+	}
+	usage := u.selectionRefills()
+	if u.PersonalStats.Refills() > usage.Total {
+		usage.Total = u.PersonalStats.Refills()
+	}
+	return usage
+}
+
+// Reconciles consecutive diffs of one user, so a refill seen by the refills selection in one
+// diff and by PersonalStats in the next is only counted once
+type RefillReconciler struct {
+	unconfirmed int // refills counted from the selection that PersonalStats hasn't caught up on
+}
+
+func (r *RefillReconciler) Reconcile(u *UserDiff) RefillUsage {
+	usage := u.selectionRefills()
+	stat := u.PersonalStats.Refills()
+	if stat >= usage.Total {
+		counted := stat - usage.Total
+		if counted > r.unconfirmed {
+			counted = r.unconfirmed
+		}
+		r.unconfirmed -= counted
+		usage.Total = stat - counted
+	} else {
+		r.unconfirmed += usage.Total - stat
+	}
+	u.ReconciledRefills = &usage
+	return usage
+}
+
+type RefillStreak struct {
+	DaysUsed      int `json:"daysUsed"`
+	CurrentStreak int `json:"currentStreak"` // consecutive days ending on the last day observed
+	LongestStreak int `json:"longestStreak"`
+}
+
+// Tracks the days (in Torn time, UTC) a user's daily energy refill was used. Snapshots must
+// be observed in order
+type RefillStreakTracker struct {
+	streak  RefillStreak
+	lastDay time.Time // last day observed
+	lastUse time.Time // last day the refill was used
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (t *RefillStreakTracker) Observe(u User, at time.Time) {
+	day := truncateToDay(at)
+	t.lastDay = day
+	if !u.Refills.EnergyRefillUsed || day.Equal(t.lastUse) {
+		return
+	}
+	t.streak.DaysUsed += 1
+	if !t.lastUse.IsZero() && day.Sub(t.lastUse) == time.Hour*24 {
+		t.streak.CurrentStreak += 1
+	} else {
+		t.streak.CurrentStreak = 1
+	}
+	if t.streak.CurrentStreak > t.streak.LongestStreak {
+		t.streak.LongestStreak = t.streak.CurrentStreak
+	}
+	t.lastUse = day
+}
+
+func (t *RefillStreakTracker) Streak() RefillStreak {
+	streak := t.streak
+	// The streak is broken once a full day passes without a refill; today may still come
+	if t.lastUse.IsZero() || t.lastDay.Sub(t.lastUse) > time.Hour*24 {
+		streak.CurrentStreak = 0
+	}
+	return streak
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	ScoringRaw      = "raw"      // energy trained
+	ScoringRelative = "relative" // energy trained in bars of the member's max energy
+	ScoringGains    = "gains"    // stat gains as a percentage of stats at the start
+)
+
+// Energy sources a competition can leave out of energy-based scores
+const (
+	SourceJobPoints = "jobpoints"
+	SourceRefills   = "refills"
+	SourceFhc       = "fhc"
+)
+
+type Scoring struct {
+	Mode    string   `json:"mode,omitempty"` // raw when empty
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (s Scoring) IsRaw() bool {
+	return (s.Mode == "" || s.Mode == ScoringRaw) && len(s.Exclude) == 0
+}
+
+func (s Scoring) Validate() error {
+	switch s.Mode {
+	case "", ScoringRaw, ScoringRelative, ScoringGains:
+	default:
+		return errors.New("invalid scoring mode, expected one of: [raw, relative, gains]")
+	}
+	for _, source := range s.Exclude {
+		if source != SourceJobPoints && source != SourceRefills && source != SourceFhc {
+			return errors.New("invalid excluded source, expected one of: [jobpoints, refills, fhc]")
+		}
+	}
+	// Gains can't be attributed to the energy source they were trained with
+	if s.Mode == ScoringGains && len(s.Exclude) > 0 {
+		return errors.New("sources can't be excluded from gains scoring")
+	}
+	return nil
+}
+
+// Short label shown next to scores
+func (s Scoring) Describe() string {
+	var label string
+	switch s.Mode {
+	case ScoringRelative:
+		label = "bars of max energy"
+	case ScoringGains:
+		label = "% stats gained"
+	default:
+		label = "energy"
+	}
+	if len(s.Exclude) > 0 {
+		label += " excluding " + strings.Join(s.Exclude, ", ")
+	}
+	return label
+}
+
+func (s Scoring) excludes(source string) bool {
+	for _, excluded := range s.Exclude {
+		if excluded == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Energy trained less the excluded sources
+func (s Scoring) ScoredEnergy(summary UserSummary) int {
+	energy := summary.Energy
+	if s.excludes(SourceJobPoints) {
+		energy -= summary.JpEnergy
+	}
+	if s.excludes(SourceRefills) {
+		energy -= summary.RefillEnergy
+	}
+	if s.excludes(SourceFhc) {
+		energy -= summary.FhcEnergy
+	}
+	if energy < 0 {
+		return 0
+	}
+	return energy
+}
+
+func (s Scoring) Score(summary UserSummary) float64 {
+	switch s.Mode {
+	case ScoringRelative:
+		if summary.MaxEnergy <= 0 {
+			return 0
+		}
+		return float64(s.ScoredEnergy(summary)) / float64(summary.MaxEnergy)
+	case ScoringGains:
+		if summary.StartingStats <= 0 {
+			return 0
+		}
+		return summary.TotalGains * 100 / summary.StartingStats
+	default:
+		return float64(s.ScoredEnergy(summary))
+	}
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestScoring_Score(t *testing.T) {
+	summary := UserSummary{Energy: 3000, JpEnergy: 300, RefillEnergy: 150, FhcEnergy: 450, MaxEnergy: 150,
+		TotalGains: 5000, StartingStats: 100000}
+	tests := []struct {
+		name    string
+		scoring Scoring
+		want    float64
+	}{
+		{"default", Scoring{}, 3000},
+		{"raw", Scoring{Mode: ScoringRaw}, 3000},
+		{"relative", Scoring{Mode: ScoringRelative}, 20},
+		{"gains", Scoring{Mode: ScoringGains}, 5},
+		{"excluding job points", Scoring{Exclude: []string{SourceJobPoints}}, 2700},
+		{"relative excluding all", Scoring{Mode: ScoringRelative, Exclude: []string{SourceJobPoints, SourceRefills, SourceFhc}}, 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.scoring.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if got := tt.scoring.Score(summary); got != tt.want {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if (Scoring{Exclude: []string{SourceFhc}}).IsRaw() {
+		t.Errorf("IsRaw() expected false with excluded sources")
+	}
+}
+
+func TestScoring_Validate(t *testing.T) {
+	invalid := []Scoring{
+		{Mode: "handicap"},
+		{Exclude: []string{"xanax"}},
+		{Mode: ScoringGains, Exclude: []string{SourceRefills}},
+	}
+	for _, scoring := range invalid {
+		if err := scoring.Validate(); err == nil {
+			t.Errorf("Validate() expected an error for %+v", scoring)
+		}
+	}
+}
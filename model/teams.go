@@ -0,0 +1,89 @@
+package model
+
+// Member summaries only cover the time each member was on the team
+type TeamSummary struct {
+	Name    string        `json:"name"`
+	Total   UserSummary   `json:"total"`
+	Members []UserSummary `json:"members"`
+}
+
+// Adds another member's summary into s. Refill streaks aren't additive and are left as
+// they are; averages are re-weighted by energy trained and scores are summed
+func (s *UserSummary) Add(o UserSummary) {
+	if s.Energy+o.Energy > 0 {
+		s.TrainHappy = (s.TrainHappy*s.Energy + o.TrainHappy*o.Energy) / (s.Energy + o.Energy)
+	}
+	s.Energy += o.Energy
+	s.FHCs += o.FHCs
+	s.Xanax += o.Xanax
+	s.LSD += o.LSD
+	s.EnergyDrinks += o.EnergyDrinks
+	s.Attacks += o.Attacks
+	s.EnergyRefills += o.EnergyRefills
+	s.SpecialRefills += o.SpecialRefills
+	s.EDVDs += o.EDVDs
+	s.Dumps += o.Dumps
+	s.JpEnergy += o.JpEnergy
+	s.Overdoses += o.Overdoses
+	if o.MaxEnergy > s.MaxEnergy {
+		s.MaxEnergy = o.MaxEnergy
+	}
+	s.RefillEnergy += o.RefillEnergy
+	s.FhcEnergy += o.FhcEnergy
+	s.StartingStats += o.StartingStats
+	s.Score += o.Score
+	if s.ScoreMode == "" {
+		s.ScoreMode = o.ScoreMode
+	}
+
+	s.StrengthGains += o.StrengthGains
+	s.SpeedGains += o.SpeedGains
+	s.DexterityGains += o.DexterityGains
+	s.DefenseGains += o.DefenseGains
+	s.TotalGains += o.TotalGains
+	if s.Energy > 0 {
+		s.GainsPerKiloEnergy = s.TotalGains * 1000 / float64(s.Energy)
+	}
+
+	s.NerveSpent += o.NerveSpent
+	s.NerveRefills += o.NerveRefills
+	s.Crimes += o.Crimes
+	for category, count := range o.CrimeCounts {
+		if s.CrimeCounts == nil {
+			s.CrimeCounts = make(map[string]int)
+		}
+		s.CrimeCounts[category] += count
+	}
+	s.Busts += o.Busts
+	s.FailedBusts += o.FailedBusts
+	s.Jailed += o.Jailed
+
+	s.DataGaps += o.DataGaps
+	s.LowConfidence += o.LowConfidence
+	if o.LongestGap > s.LongestGap {
+		s.LongestGap = o.LongestGap
+	}
+}
+
+// Sums member summaries into a team; a member listed more than once (e.g. after leaving
+// and rejoining) is merged into one entry
+func NewTeamSummary(name string, members []UserSummary) TeamSummary {
+	team := TeamSummary{Name: name, Total: UserSummary{Name: name}, Members: []UserSummary{}}
+	index := make(map[uint]int)
+	for _, member := range members {
+		team.Total.Add(member)
+		if i, found := index[member.User]; found {
+			team.Members[i].Add(member)
+			if member.Name != "" {
+				team.Members[i].Name = member.Name
+			}
+			continue
+		}
+		index[member.User] = len(team.Members)
+		merged := member
+		merged.CrimeCounts = nil
+		merged.Add(UserSummary{CrimeCounts: member.CrimeCounts}) // copies the map, which later merges modify
+		team.Members = append(team.Members, merged)
+	}
+	return team
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+func IsBucket(bucket string) bool {
+	return bucket == BucketHour || bucket == BucketDay || bucket == BucketWeek
+}
+
+// Start of the bucket containing t, in UTC (Torn time); weeks start on Monday
+func BucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case BucketHour:
+		return t.Truncate(time.Hour)
+	case BucketWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketHour:
+		return t.Add(time.Hour)
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+type TimeSeriesPoint struct {
+	Start  time.Time `json:"start"`
+	Energy int       `json:"energy"` // trained in the bucket
+	Gains  float64   `json:"gains"`  // total stats gained in the bucket
+
+	// Stat totals at the end of the bucket, carried over from earlier buckets without snapshots
+	Strength  float64 `json:"strength"`
+	Speed     float64 `json:"speed"`
+	Dexterity float64 `json:"dexterity"`
+	Defense   float64 `json:"defense"`
+	Total     float64 `json:"total"`
+}
+
+type TimeSeries struct {
+	UserId uint              `json:"userId"`
+	Name   string            `json:"name"`
+	Bucket string            `json:"bucket"`
+	Points []TimeSeriesPoint `json:"points"`
+
+	index map[time.Time]int
+}
+
+// Creates a point for every bucket overlapping earliest to latest, so series of different
+// users line up
+func NewTimeSeries(userId uint, bucket string, earliest time.Time, latest time.Time) (*TimeSeries, error) {
+	if !IsBucket(bucket) {
+		return nil, errors.New("invalid bucket, expected one of: [hour, day, week]")
+	}
+	ts := &TimeSeries{UserId: userId, Bucket: bucket, Points: []TimeSeriesPoint{}, index: make(map[time.Time]int)}
+	for start := BucketStart(earliest, bucket); start.Before(latest); start = nextBucket(start, bucket) {
+		ts.index[start] = len(ts.Points)
+		ts.Points = append(ts.Points, TimeSeriesPoint{Start: start})
+	}
+	return ts, nil
+}
+
+func (ts *TimeSeries) point(t time.Time) *TimeSeriesPoint {
+	i, ok := ts.index[BucketStart(t, ts.Bucket)]
+	if !ok {
+		return nil
+	}
+	return &ts.Points[i]
+}
+
+// Records the stat totals of a snapshot taken at t; snapshots are expected in order
+func (ts *TimeSeries) AddSnapshot(u User, at time.Time) {
+	if u.Name != "" {
+		ts.Name = u.Name
+	}
+	p := ts.point(at)
+	if p == nil {
+		return
+	}
+	p.Strength, _ = ToFloat(u.BattleStats.Strength).Float64()
+	p.Speed, _ = ToFloat(u.BattleStats.Speed).Float64()
+	p.Dexterity, _ = ToFloat(u.BattleStats.Dexterity).Float64()
+	p.Defense, _ = ToFloat(u.BattleStats.Defense).Float64()
+	p.Total = p.Strength + p.Speed + p.Dexterity + p.Defense
+}
+
+// Attributes a diff ending at to; trained is passed in so callers can apply an anomaly policy
+func (ts *TimeSeries) AddDiff(u UserDiff, trained int, to time.Time) {
+	p := ts.point(to)
+	if p == nil {
+		return
+	}
+	gains, _ := u.BattleStats.GetTotalGains().Float64()
+	p.Energy += trained
+	p.Gains += gains
+}
+
+// Carries stat totals into buckets without snapshots
+func (ts *TimeSeries) Finish() {
+	for i := 1; i < len(ts.Points); i++ {
+		if ts.Points[i].Total == 0 {
+			prev := ts.Points[i-1]
+			ts.Points[i].Strength = prev.Strength
+			ts.Points[i].Speed = prev.Speed
+			ts.Points[i].Dexterity = prev.Dexterity
+			ts.Points[i].Defense = prev.Defense
+			ts.Points[i].Total = prev.Total
+		}
+	}
+}
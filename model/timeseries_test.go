@@ -0,0 +1,58 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	at := time.Date(2019, time.August, 29, 15, 42, 10, 0, time.UTC) // Thursday
+	tests := []struct {
+		bucket string
+		want   time.Time
+	}{
+		{BucketHour, time.Date(2019, time.August, 29, 15, 0, 0, 0, time.UTC)},
+		{BucketDay, time.Date(2019, time.August, 29, 0, 0, 0, 0, time.UTC)},
+		{BucketWeek, time.Date(2019, time.August, 26, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bucket, func(t *testing.T) {
+			if got := BucketStart(at, tt.bucket); !got.Equal(tt.want) {
+				t.Errorf("BucketStart() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	earliest := time.Date(2019, time.August, 24, 0, 0, 0, 0, time.UTC)
+	latest := time.Date(2019, time.August, 27, 0, 0, 0, 0, time.UTC)
+	if _, err := NewTimeSeries(1, "month", earliest, latest); err == nil {
+		t.Errorf("NewTimeSeries() expected an error for an invalid bucket")
+	}
+	ts, err := NewTimeSeries(1, BucketDay, earliest, latest)
+	if err != nil {
+		t.Fatalf("NewTimeSeries() error = %v", err)
+	}
+	stats := func(strength string) User {
+		return User{Name: "Epi", BattleStats: BattleStats{Strength: strength, Speed: "10", Dexterity: "10", Defense: "10"}}
+	}
+	first, second := earliest.Add(time.Hour), earliest.Add(time.Hour*2)
+	ts.AddSnapshot(stats("10"), first)
+	ts.AddSnapshot(stats("15"), second)
+	ts.AddDiff(stats("10").Diff(stats("15")), 50, second)
+	ts.AddDiff(UserDiff{}, 25, latest) // outside the range
+	ts.Finish()
+
+	if len(ts.Points) != 3 || ts.Name != "Epi" {
+		t.Fatalf("unexpected series: %+v", ts)
+	}
+	if p := ts.Points[0]; p.Energy != 50 || p.Gains != 5 || p.Total != 45 {
+		t.Errorf("first bucket = %+v", p)
+	}
+	for _, p := range ts.Points[1:] {
+		if p.Energy != 0 || p.Strength != 15 || p.Total != 45 {
+			t.Errorf("carried bucket = %+v", p)
+		}
+	}
+}
@@ -2,11 +2,77 @@ package model
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
+// Longer than a regen tick, so a user below max energy should have produced a snapshot
+const MaxSnapshotGap = time.Minute * 20
+
 type UserDiff struct {
 	User
 	MaxEnergy int `json:"maxEnergy,omitempty"`
+	MaxNerve  int `json:"maxNerve,omitempty"`
+
+	// Only set by DiffAt
+	Elapsed        time.Duration `json:"elapsed,omitempty"`
+	EstimatedRegen int           `json:"estimatedRegen,omitempty"`
+	EstimatedNerveRegen int      `json:"estimatedNerveRegen,omitempty"`
+	DataGap        bool          `json:"dataGap,omitempty"`       // snapshots are missing from the interval
+	LowConfidence  bool          `json:"lowConfidence,omitempty"` // a gap blends training or other events, or spending after a full bar hides regen
+	Rules          *EnergyRules  `json:"-"`                       // rules in effect at from
+
+	// Only set by RefillReconciler
+	ReconciledRefills *RefillUsage `json:"-"`
+	// Only set by MergeGaps
+	Merged *MergedGaps `json:"-"`
+}
+
+// Gap flags of the intervals retention merged into one, so summaries of old competitions
+// keep reporting the gaps they had before pruning
+type MergedGaps struct {
+	DataGaps      int           `json:"dataGaps,omitempty"`
+	LowConfidence int           `json:"lowConfidence,omitempty"`
+	LongestGap    time.Duration `json:"longestGap,omitempty"`
+}
+
+// Adds the flags of one of the merged intervals
+func (m *MergedGaps) Add(u UserDiff) {
+	if u.Merged != nil {
+		m.DataGaps += u.Merged.DataGaps
+		m.LowConfidence += u.Merged.LowConfidence
+		if u.Merged.LongestGap > m.LongestGap {
+			m.LongestGap = u.Merged.LongestGap
+		}
+		return
+	}
+	if u.DataGap {
+		m.DataGaps += 1
+		if u.Elapsed > m.LongestGap {
+			m.LongestGap = u.Elapsed
+		}
+	}
+	if u.LowConfidence {
+		m.LowConfidence += 1
+	}
+}
+
+// Replaces the diff's own gap flags with those of the intervals it was merged from
+func (u *UserDiff) MergeGaps(merged *MergedGaps) {
+	if merged == nil {
+		return
+	}
+	u.Merged = merged
+	u.DataGap = merged.DataGaps > 0
+	u.LowConfidence = merged.LowConfidence > 0
+}
+
+// Falls back to the latest active rules for diffs not taken with DiffAt
+func (u UserDiff) EnergyRules() EnergyRules {
+	if u.Rules != nil {
+		return *u.Rules
+	}
+	return ActiveEnergyRules.Latest()
 }
 
 func (u User) Diff(u2 User) UserDiff {
@@ -18,30 +84,79 @@ func (u User) Diff(u2 User) UserDiff {
 	diff.Refills = u.Refills.Diff(u2.Refills)
 	diff.UserId = u.UserId
 	diff.MaxEnergy = u.Bars.Energy.Maximum
+	diff.MaxNerve = u.Bars.Nerve.Maximum
 	diff.Items = CalculateItemDiffs(u.Items, u2.Items)
 	return diff
 }
 
-func (u UserDiff) GetEvents() []string {
-	var events []string
-	if psEvents := u.PersonalStats.GetEvents(); len(psEvents) > 0 {
+// Diffs snapshots taken at from and to, accounting for passive regen in between
+func (u User) DiffAt(u2 User, from time.Time, to time.Time) UserDiff {
+	diff := u.Diff(u2)
+	rules := ActiveEnergyRules.At(from)
+	diff.Rules = &rules
+	diff.Elapsed = to.Sub(from)
+	diff.EstimatedRegen = u.Bars.Energy.EstimateRegen(diff.Elapsed)
+	diff.EstimatedNerveRegen = u.Bars.Nerve.EstimateRegen(diff.Elapsed)
+	diff.DataGap = diff.Elapsed > MaxSnapshotGap && u.Bars.Energy.Current < u.Bars.Energy.Maximum
+	diff.LowConfidence = diff.DataGap && (diff.IsTrain() || len(diff.GetEvents()) > 0) ||
+		u.Bars.Energy.RegenUncertain(diff.Elapsed, diff.IsTrain()) ||
+		u.Bars.Nerve.RegenUncertain(diff.Elapsed, diff.IsNerveSpent())
+	return diff
+}
+
+func (u UserDiff) GetEvents() []Event {
+	var events []Event
+	rules := u.EnergyRules()
+	if psEvents := u.PersonalStats.GetEvents(rules); len(psEvents) > 0 {
 		events = append(events, psEvents...)
 	}
+	if refills := u.CalculateRefills(); refills.Total > 0 {
+		events = append(events, Event{EventRefill, fmt.Sprintf("gained %de by using %d energy refills (daily=%d, special=%d)",
+			refills.Total * u.MaxEnergy, refills.Total, refills.Daily, refills.Special)})
+	}
+	if refills := u.PersonalStats.NerveRefills(); refills > 0 {
+		events = append(events, Event{EventNerveRefill, fmt.Sprintf("refilled nerve %d times", refills)})
+	}
+	ps := u.PersonalStats
+	if nerve := u.CalculateNerveSpent(); ps.CriminalOffenses() > 0 {
+		var categories []string
+		for _, c := range ps.CrimeCounts() {
+			categories = append(categories, c.String())
+		}
+		events = append(events, Event{EventCrime, fmt.Sprintf("committed %d crimes [%s] spending %d nerve",
+			ps.CriminalOffenses(), strings.Join(categories, ", "), nerve)})
+	} else if nerve > 0 {
+		events = append(events, Event{EventCrime, fmt.Sprintf("spent %d nerve on crimes", nerve)})
+	}
+	if busts := ps.PeopleBusted() + ps.FailedBusts(); busts > 0 {
+		events = append(events, Event{EventBust, fmt.Sprintf("attempted %d busts (%d failed)", busts, ps.FailedBusts())})
+	}
+	if ps.Jailed() > 0 {
+		events = append(events, Event{EventJailed, "got jailed"})
+	}
+	if cans := u.PersonalStats.EnergyDrinkUsed(); cans > 0 {
+		energy, exact := u.CalculateEnergyDrinkEnergy()
+		estimated := "*"
+		if exact {
+			estimated = ""
+		}
+		events = append(events, Event{EventEnergyDrink, fmt.Sprintf("gained %de%s by consuming %d energy drinks", energy, estimated, cans)})
+	}
 	if jpEnergyGained, jpSpent := u.CalculateEnergyGainedFromJobPoints(); jpEnergyGained > 0 {
-		events = append(events, fmt.Sprintf("gained %de by spending %d job points", jpEnergyGained, jpSpent))
+		events = append(events, Event{EventJobPoints, fmt.Sprintf("gained %de by spending %d job points", jpEnergyGained, jpSpent)})
 	}
-	fhc, edvd := CalculateBoosterSplit(u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken,
-		u.PersonalStats.BoostersUsed, u.PersonalStats.Overdosed, u.Bars.Energy.Current, u.IsTrain())
+	fhc, edvd := CalculateBoosterSplit(rules, u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken(),
+		u.PersonalStats.BoostersUsed(), u.PersonalStats.Overdosed(), u.Bars.Energy.Current, u.IsTrain())
 	if fhc > 0 {
-		events = append(events, fmt.Sprintf("gained %de* by using %d FHCs", 150 * fhc, fhc))
+		events = append(events, Event{EventFhc, fmt.Sprintf("gained %de* by using %d FHCs", rules.Fhc * fhc, fhc)})
 	}
 	if edvd > 0 {
-		events = append(events, fmt.Sprintf("gained %d happy by watching %d eDVDs", edvd * 2500, edvd))
+		events = append(events, Event{EventEdvd, fmt.Sprintf("gained %d happy by watching %d eDVDs", edvd * rules.EdvdHappy, edvd)})
 	}
 	gains := u.BattleStats.GetTotalGains()
 	trained := u.CalculateEnergyTrained()
 	if t, _ := gains.Float64(); t > 0 || trained > 0 {
-		events = append(events, fmt.Sprintf("trained %de gaining %s stats", trained, gains.Text('f', 4)))
+		events = append(events, Event{EventTrain, fmt.Sprintf("trained %de gaining %s stats", trained, gains.Text('f', 4))})
 	}
 	return events
 }
@@ -51,7 +166,7 @@ func (u UserDiff) IsTrain() bool {
 }
 
 // returns (fhc, edvd)
-func CalculateBoosterSplit(prevHappy int, currHappy int, ecstasyTaken int, boostersTaken int, od int, currEnergy int, train bool) (int, int) {
+func CalculateBoosterSplit(rules EnergyRules, prevHappy int, currHappy int, ecstasyTaken int, boostersTaken int, od int, currEnergy int, train bool) (int, int) {
 	var edvd int
 	var fhc int
 	boosters := boostersTaken
@@ -70,63 +185,102 @@ func CalculateBoosterSplit(prevHappy int, currHappy int, ecstasyTaken int, boost
 			}
 			return 0, boostersTaken
 		}
-		// 400f + 2400e = h
-		// f + e = b => 400f + 400e = 400b
+		// Hf*f + (Hf+S)e = h
+		// f + e = b => Hf*f + Hf*e = Hf*b
 		// --------
-		// 2000e = h - 400b
-		// e = (h-400b)/2000
-		edvd = (happy - (400 * boostersTaken)) / 2000
+		// S*e = h - Hf*b
+		// e = (h-Hf*b)/S
+		split := rules.BoosterSplit.withDefaults()
+		extra := happy - split.FhcHappy*boostersTaken
+		if !split.Round {
+			edvd = extra / split.Step
+		} else if extra > 0 {
+			// To the nearest booster as happy drifts with regen
+			edvd = (extra + split.Step/2) / split.Step
+			if edvd > boostersTaken {
+				edvd = boostersTaken
+			}
+		}
 		fhc = boostersTaken - edvd
 	}
 	return fhc, edvd
 }
 
+// Only companies with a job point rule count towards energy or points spent
 func (u UserDiff) CalculateEnergyGainedFromJobPoints() (int, int) {
-	var gameShop, candle, farm, furniture, pub, restaurant int
+	perPoint := u.EnergyRules().JobPoints
+	var pointsSpent, jobEnergy int
 	for _, j := range u.Jobs {
 		if j.Points > 0 {
 			continue
 		}
-		if j.Name == "Game Shop" {
-			gameShop = -1 * j.Points
-		} else if j.Name == "Candle Shop" {
-			candle = -1 * j.Points
-		} else if j.Name == "Farm" {
-			farm = -1 * j.Points
-		} else if j.Name == "Furniture Store" {
-			furniture = -1 * j.Points
-		} else if j.Name == "Pub" {
-			pub = -1 * j.Points
-		} else if j.Name == "Restaurant" {
-			restaurant = -1 * j.Points
+		if energy, ok := perPoint[j.Name]; ok {
+			pointsSpent += -1 * j.Points
+			jobEnergy += energy * -1 * j.Points
 		}
 	}
-	pointsSpent := gameShop + candle + farm + furniture + pub + restaurant
-	jobEnergy := (5 * gameShop) + (5 * candle) + (7 * farm) + (3 * furniture) + (3 * pub) + (3 * restaurant)
 	return jobEnergy, pointsSpent
 }
 
+// Values cans consumed by matching inventory decreases to can types; exact when the decreases
+// account for every can used. Unmatched cans count at the rules' EnergyDrink value, as do all
+// cans when more left the inventory than were used (e.g. sold or traded)
+func (u UserDiff) CalculateEnergyDrinkEnergy() (int, bool) {
+	rules := u.EnergyRules()
+	used := u.PersonalStats.EnergyDrinkUsed()
+	if used <= 0 {
+		return 0, true
+	}
+	var consumed, energy int
+	for _, item := range u.Items {
+		if value, ok := rules.EnergyDrinks[item.Id]; ok && item.Quantity < 0 {
+			consumed += -1 * item.Quantity
+			energy += value * -1 * item.Quantity
+		}
+	}
+	if consumed > used {
+		return rules.EnergyDrink * used, false
+	}
+	return energy + rules.EnergyDrink*(used-consumed), consumed == used
+}
+
+// Nerve only drops through crimes, so any drop or refill means nerve was spent
+func (u UserDiff) IsNerveSpent() bool {
+	return u.Bars.Nerve.Current < u.Bars.Nerve.Previous || u.PersonalStats.NerveRefills() > 0
+}
+
+// Nerve available over the interval (what was left, refills and regen) less what remains.
+// Refills are assumed to add the maximum nerve, like energy refills
+func (u UserDiff) CalculateNerveSpent() int {
+	spent := u.Bars.Nerve.Previous + u.PersonalStats.NerveRefills()*u.MaxNerve + u.EstimatedNerveRegen - u.Bars.Nerve.Current
+	if spent < 0 {
+		return 0
+	}
+	return spent
+}
+
 func (u UserDiff) CalculateEnergyTrained() int {
 	if !u.IsTrain() {
 		return 0
 	}
-	prfEnergy := u.PersonalStats.Refills * u.MaxEnergy
-	xanEnergy := 250 * u.PersonalStats.XanaxTaken
-	lsdEnergy := 50 * u.PersonalStats.LsdTaken
+	rules := u.EnergyRules()
+	prfEnergy := u.CalculateRefills().Total * u.MaxEnergy
+	xanEnergy := rules.Xanax * u.PersonalStats.XanaxTaken()
+	lsdEnergy := rules.Lsd * u.PersonalStats.LsdTaken()
 	ps := u.PersonalStats
-	attacks := ps.AttacksWon + ps.AttacksLost + ps.AttacksDraw + ps.AttacksAssisted + ps.YouRunAway
-	attacksEnergy := -25 * attacks
-	dumpEnergy := -5 * ps.DumpSearches
-	energyDrinkEnergy := 30 * ps.EnergyDrinkUsed
+	attacks := ps.AttacksWon() + ps.AttacksLost() + ps.AttacksDraw() + ps.AttacksAssisted() + ps.YouRunAway()
+	attacksEnergy := -rules.AttackCost * attacks
+	dumpEnergy := -rules.DumpCost * ps.DumpSearches()
+	energyDrinkEnergy, _ := u.CalculateEnergyDrinkEnergy()
 	// Heuristic to split Booster into FHCs v. EDVDs
-	fhc, _ := CalculateBoosterSplit(u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken,
-		u.PersonalStats.BoostersUsed, u.PersonalStats.Overdosed, u.Bars.Energy.Current, u.IsTrain())
-	fhcEnergy := 150 * fhc
+	fhc, _ := CalculateBoosterSplit(rules, u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken(),
+		u.PersonalStats.BoostersUsed(), u.PersonalStats.Overdosed(), u.Bars.Energy.Current, u.IsTrain())
+	fhcEnergy := rules.Fhc * fhc
 
 	unspentEnergy := -1 * u.Bars.Energy.Current
 	jpEnergy, _ := u.CalculateEnergyGainedFromJobPoints()
 	eTrained := u.Bars.Energy.Previous + prfEnergy + xanEnergy + lsdEnergy + unspentEnergy + attacksEnergy +
-		dumpEnergy + energyDrinkEnergy + fhcEnergy + jpEnergy
+		dumpEnergy + energyDrinkEnergy + fhcEnergy + jpEnergy + u.EstimatedRegen
 	return eTrained
 }
 
@@ -140,28 +294,97 @@ type UserSummary struct {
 	EnergyDrinks  int
 	Attacks       int
 	EnergyRefills int
+	SpecialRefills int
+	RefillStreak  RefillStreak
 	EDVDs         int
 	Dumps         int
 	JpEnergy	  int
 	Overdoses	  int
+	MaxEnergy     int // highest seen
+	RefillEnergy  int // gained from energy refills
+	FhcEnergy     int // gained from FHCs
+	StartingStats float64 // total battle stats at the first snapshot
+
+	// Set by the reporter for competitions with a Scoring
+	Score     float64
+	ScoreMode string
+
+	StrengthGains      float64
+	SpeedGains         float64
+	DexterityGains     float64
+	DefenseGains       float64
+	TotalGains         float64
+	GainsPerKiloEnergy float64 // stat gains per 1000 energy trained
+	TrainHappy         int     // average happy before each train, weighted by energy trained
+
+	NerveSpent   int
+	NerveRefills int
+	Crimes       int
+	CrimeCounts  map[string]int // by CrimeCount category
+	Busts        int // attempted, as in the bust events
+	FailedBusts  int
+	Jailed       int
+
+	DataGaps      int           // intervals with missing snapshots
+	LowConfidence int           // gaps that blend training or other events
+	LongestGap    time.Duration
 }
 
 func (u UserDiff) AddToSummary(summary *UserSummary) {
-	summary.EnergyRefills += u.PersonalStats.Refills
-	summary.Xanax += u.PersonalStats.XanaxTaken
-	summary.LSD += u.PersonalStats.LsdTaken
+	u.AddToSummaryWithEnergy(summary, u.CalculateEnergyTrained())
+}
+
+// Adds the diff to the summary, overriding the energy trained (e.g. when capped)
+func (u UserDiff) AddToSummaryWithEnergy(summary *UserSummary, trained int) {
+	refills := u.CalculateRefills()
+	summary.EnergyRefills += refills.Total
+	summary.SpecialRefills += refills.Special
+	summary.Xanax += u.PersonalStats.XanaxTaken()
+	summary.LSD += u.PersonalStats.LsdTaken()
 	ps := u.PersonalStats
-	summary.Attacks += ps.AttacksWon + ps.AttacksLost + ps.AttacksDraw + ps.AttacksAssisted + ps.YouRunAway
-	summary.Dumps += ps.DumpSearches
-	summary.EnergyDrinks += ps.EnergyDrinkUsed
-	summary.Overdoses += ps.Overdosed
+	summary.Attacks += ps.AttacksWon() + ps.AttacksLost() + ps.AttacksDraw() + ps.AttacksAssisted() + ps.YouRunAway()
+	summary.Dumps += ps.DumpSearches()
+	summary.EnergyDrinks += ps.EnergyDrinkUsed()
+	summary.Overdoses += ps.Overdosed()
 
 	// Heuristic to split Booster into FHCs v. EDVDs
-	fhc, edvd := CalculateBoosterSplit(u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken,
-		u.PersonalStats.BoostersUsed, u.PersonalStats.Overdosed, u.Bars.Energy.Current, u.IsTrain())
+	fhc, edvd := CalculateBoosterSplit(u.EnergyRules(), u.Bars.Happy.Previous, u.Bars.Happy.Current, u.PersonalStats.EcstasyTaken(),
+		u.PersonalStats.BoostersUsed(), u.PersonalStats.Overdosed(), u.Bars.Energy.Current, u.IsTrain())
 	summary.FHCs += fhc
 	summary.EDVDs += edvd
+	summary.RefillEnergy += refills.Total * u.MaxEnergy
+	summary.FhcEnergy += fhc * u.EnergyRules().Fhc
+	if u.MaxEnergy > summary.MaxEnergy {
+		summary.MaxEnergy = u.MaxEnergy
+	}
 	jpEnergy, _ := u.CalculateEnergyGainedFromJobPoints()
 	summary.JpEnergy += jpEnergy
-	summary.Energy += u.CalculateEnergyTrained()
+
+	if trained > 0 && summary.Energy+trained > 0 {
+		summary.TrainHappy = (summary.TrainHappy*summary.Energy + u.Bars.Happy.Previous*trained) / (summary.Energy + trained)
+	}
+	summary.Energy += trained
+	var gaps MergedGaps
+	gaps.Add(u)
+	summary.DataGaps += gaps.DataGaps
+	summary.LowConfidence += gaps.LowConfidence
+	if gaps.LongestGap > summary.LongestGap {
+		summary.LongestGap = gaps.LongestGap
+	}
+	summary.NerveSpent += u.CalculateNerveSpent()
+	summary.NerveRefills += u.PersonalStats.NerveRefills()
+	summary.Crimes += ps.CriminalOffenses()
+	for _, c := range ps.CrimeCounts() {
+		if summary.CrimeCounts == nil {
+			summary.CrimeCounts = make(map[string]int)
+		}
+		summary.CrimeCounts[c.Category] += c.Count
+	}
+	summary.Busts += ps.PeopleBusted() + ps.FailedBusts()
+	summary.FailedBusts += ps.FailedBusts()
+	summary.Jailed += ps.Jailed()
+	u.BattleStats.AddToSummary(summary)
+	if summary.Energy > 0 {
+		summary.GainsPerKiloEnergy = summary.TotalGains * 1000 / float64(summary.Energy)
+	}
 }
\ No newline at end of file
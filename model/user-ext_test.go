@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const BEFORE = `{
@@ -174,27 +175,17 @@ const DIFF = `{
     }
   ],
   "personalstats": {
-    "alcoholused": 0,
-    "attacksassisted": 0,
-    "attacksdraw": 0,
-    "attackslost": 0,
     "attackswon": 69,
-    "booksread": 0,
     "boostersused": 31,
     "candyused": 1,
-    "cantaken": 0,
     "consumablesused": 2,
     "dumpsearches": 7,
     "energydrinkused": 1,
-    "exttaken": 0,
-    "logins": 0,
-    "lsdtaken": 0,
     "nerverefills": 7,
     "overdosed": 1,
     "refills": 7,
     "useractivity": 41676,
-    "xantaken": 19,
-    "yourunaway": 0
+    "xantaken": 19
   },
   "refills": {
     "energy_refill_used": true
@@ -238,7 +229,7 @@ func TestUser_Diff(t *testing.T) {
 	if err := json.Unmarshal([]byte(DIFF), &want); err != nil {
 		t.Errorf("Unable to unmarshal DIFF: %s", err)
 	}
-	if got := before.Diff(after); !reflect.DeepEqual(got, want) {
+	if got := before.Diff(after); !reflect.DeepEqual(got, UserDiff{User: want, MaxEnergy: 150}) {
 		t.Errorf("Diff() = %+v, want %+v", got, want)
 	}
 }
@@ -272,7 +263,7 @@ func TestCalculateBoosterSplit(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1 := CalculateBoosterSplit(tt.args.prevHappy, tt.args.currHappy, tt.args.ecstasyTaken,
+			got, got1 := CalculateBoosterSplit(DefaultEnergyRules(), tt.args.prevHappy, tt.args.currHappy, tt.args.ecstasyTaken,
 				tt.args.boostersTaken, tt.args.od, tt.args.currEnergy, tt.args.train)
 			if got != tt.want {
 				t.Errorf("CalculateBoosterSplit() (fhc) got = %v, want %v", got, tt.want)
@@ -282,4 +273,292 @@ func TestCalculateBoosterSplit(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestUserDiff_AddToSummary(t *testing.T) {
+	var before User
+	var after User
+	if err := json.Unmarshal([]byte(BEFORE), &before); err != nil {
+		t.Errorf("Unable to unmarshal BEFORE: %s", err)
+	}
+	if err := json.Unmarshal([]byte(AFTER), &after); err != nil {
+		t.Errorf("Unable to unmarshal AFTER: %s", err)
+	}
+	var summary UserSummary
+	before.Diff(after).AddToSummary(&summary)
+	if summary.StrengthGains != 1042501.2602 || summary.SpeedGains != 33602195.8422 || summary.DefenseGains != 0 {
+		t.Errorf("AddToSummary() gains = %+v", summary)
+	}
+	if summary.TrainHappy != 4905 {
+		t.Errorf("AddToSummary() TrainHappy = %d, want 4905", summary.TrainHappy)
+	}
+	if want := summary.TotalGains * 1000 / float64(summary.Energy); summary.Energy <= 0 || summary.GainsPerKiloEnergy != want {
+		t.Errorf("AddToSummary() GainsPerKiloEnergy = %f, want %f", summary.GainsPerKiloEnergy, want)
+	}
+}
+
+func TestUserDiff_Validate(t *testing.T) {
+	var before User
+	var after User
+	if err := json.Unmarshal([]byte(BEFORE), &before); err != nil {
+		t.Errorf("Unable to unmarshal BEFORE: %s", err)
+	}
+	if err := json.Unmarshal([]byte(AFTER), &after); err != nil {
+		t.Errorf("Unable to unmarshal AFTER: %s", err)
+	}
+	start := time.Date(2019, time.August, 25, 0, 0, 0, 0, time.UTC)
+	// 19 xanax can't be taken in five minutes
+	if reasons := before.DiffAt(after, start, start.Add(time.Minute*5)).Validate(); len(reasons) != 1 {
+		t.Errorf("Validate() (5m) = %v, want one reason", reasons)
+	}
+	if reasons := before.DiffAt(after, start, start.Add(time.Hour*24*5)).Validate(); len(reasons) != 0 {
+		t.Errorf("Validate() (5d) = %v, want none", reasons)
+	}
+	if reasons := ValidateGainsPerEnergy(1000000, 10, 50); len(reasons) != 1 {
+		t.Errorf("ValidateGainsPerEnergy() = %v, want one reason", reasons)
+	}
+}
+
+
+func TestUser_DiffAt(t *testing.T) {
+	var before User
+	var after User
+	if err := json.Unmarshal([]byte(BEFORE), &before); err != nil {
+		t.Errorf("Unable to unmarshal BEFORE: %s", err)
+	}
+	if err := json.Unmarshal([]byte(AFTER), &after); err != nil {
+		t.Errorf("Unable to unmarshal AFTER: %s", err)
+	}
+	start := time.Date(2019, time.August, 25, 0, 0, 0, 0, time.UTC)
+	trained := before.Diff(after).CalculateEnergyTrained()
+
+	short := before.DiffAt(after, start, start.Add(time.Minute))
+	if short.DataGap || short.LowConfidence || short.EstimatedRegen != 0 {
+		t.Errorf("DiffAt() (1m) = gap %t, low %t, regen %d", short.DataGap, short.LowConfidence, short.EstimatedRegen)
+	}
+	long := before.DiffAt(after, start, start.Add(time.Hour*3))
+	// 150 max energy regens 5e every 10 minutes, but only the 55e of headroom from 95e
+	if !long.DataGap || !long.LowConfidence || long.EstimatedRegen != 55 {
+		t.Errorf("DiffAt() (3h) = gap %t, low %t, regen %d", long.DataGap, long.LowConfidence, long.EstimatedRegen)
+	}
+	if got := long.CalculateEnergyTrained(); got != trained+55 {
+		t.Errorf("CalculateEnergyTrained() = %d, want %d", got, trained+55)
+	}
+}
+
+func TestUser_DiffAt_FullEnergy(t *testing.T) {
+	start := time.Date(2019, time.August, 25, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		current int
+	}{
+		{"full", 150},
+		{"stacked", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := User{BattleStats: BattleStats{Strength: "1000", Speed: "1", Dexterity: "1", Defense: "1"},
+				Bars: Bars{Energy: Energy{Current: tt.current, Maximum: 150}}}
+			after := User{BattleStats: BattleStats{Strength: "2000", Speed: "1", Dexterity: "1", Defense: "1"},
+				Bars: Bars{Energy: Energy{Current: 0, Maximum: 150}}}
+			// Nothing regenerates at or above max, and when it was spent is unknown
+			diff := before.DiffAt(after, start, start.Add(time.Hour*8))
+			if diff.EstimatedRegen != 0 || !diff.LowConfidence || diff.DataGap {
+				t.Errorf("DiffAt() = regen %d, low %t, gap %t, want 0, true, false", diff.EstimatedRegen, diff.LowConfidence, diff.DataGap)
+			}
+			if got := diff.CalculateEnergyTrained(); got != tt.current {
+				t.Errorf("CalculateEnergyTrained() = %d, want %d", got, tt.current)
+			}
+			// Too short for regen to have been missed
+			if short := before.DiffAt(after, start, start.Add(time.Minute)); short.LowConfidence {
+				t.Errorf("DiffAt() (1m) low confidence")
+			}
+		})
+	}
+}
+
+// The split before it was versioned, which the default rules must keep reproducing
+func baselineBoosterSplit(prevHappy int, currHappy int, ecstasyTaken int, boostersTaken int, od int, currEnergy int, train bool) (int, int) {
+	if boostersTaken <= 0 {
+		return 0, 0
+	}
+	if currEnergy >= 400 {
+		return 0, boostersTaken
+	}
+	happy := currHappy
+	if ecstasyTaken > 0 {
+		happy /= 2
+	}
+	happy -= prevHappy
+	if happy <= -5000 && currHappy > 10 && od == 0 {
+		if train {
+			return boostersTaken, 0
+		}
+		return 0, boostersTaken
+	}
+	edvd := (happy - (400 * boostersTaken)) / 2000
+	return boostersTaken - edvd, edvd
+}
+
+func TestCalculateBoosterSplit_DefaultMatchesBaseline(t *testing.T) {
+	rules := DefaultEnergyRules()
+	for boosters := 1; boosters <= 10; boosters++ {
+		for gained := -6000; gained <= 30000; gained += 50 {
+			for ecstasy := 0; ecstasy <= 1; ecstasy++ {
+				prev := 5000
+				curr := prev + gained
+				if ecstasy > 0 {
+					curr *= 2
+				}
+				fhc, edvd := CalculateBoosterSplit(rules, prev, curr, ecstasy, boosters, 0, 0, false)
+				wantFhc, wantEdvd := baselineBoosterSplit(prev, curr, ecstasy, boosters, 0, 0, false)
+				if fhc != wantFhc || edvd != wantEdvd {
+					t.Fatalf("CalculateBoosterSplit(%d boosters, %+d happy, ecstasy %d) = %d, %d, want %d, %d",
+						boosters, gained, ecstasy, fhc, edvd, wantFhc, wantEdvd)
+				}
+			}
+		}
+	}
+}
+
+func TestCalculateBoosterSplit_Rules(t *testing.T) {
+	rules := DefaultEnergyRules()
+	// One booster worth 2100 happy: an FHC when truncating, an eDVD rounding to the nearest
+	if fhc, edvd := CalculateBoosterSplit(rules, 5000, 7100, 0, 1, 0, 0, false); fhc != 1 || edvd != 0 {
+		t.Errorf("CalculateBoosterSplit() = %d, %d, want 1, 0", fhc, edvd)
+	}
+	rules.BoosterSplit = BoosterSplit{FhcHappy: 400, Step: 2100, Round: true}
+	if fhc, edvd := CalculateBoosterSplit(rules, 5000, 7100, 0, 1, 0, 0, false); fhc != 0 || edvd != 1 {
+		t.Errorf("CalculateBoosterSplit() rounding = %d, %d, want 0, 1", fhc, edvd)
+	}
+	// Rounding never splits out more eDVDs than boosters
+	if fhc, edvd := CalculateBoosterSplit(rules, 5000, 15000, 0, 2, 0, 0, false); fhc != 0 || edvd != 2 {
+		t.Errorf("CalculateBoosterSplit() rounding = %d, %d, want 0, 2", fhc, edvd)
+	}
+}
+
+func TestUserDiff_CalculateEnergyDrinkEnergy(t *testing.T) {
+	tests := []struct {
+		name      string
+		used      int
+		items     []Item
+		want      int
+		wantExact bool
+	}{
+		{"matched", 2, []Item{{Id: 985, Quantity: -1}, {Id: 533, Quantity: -1}, {Id: FHC, Quantity: -1}}, 35, true},
+		{"unmatched can", 2, []Item{{Id: 986, Quantity: -1}}, 40, false},
+		{"sold cans", 1, []Item{{Id: 986, Quantity: -3}}, 30, false},
+		{"acquired cans", 1, []Item{{Id: 533, Quantity: 2}}, 30, false},
+	}
+	for _, tt := range tests {
+		diff := UserDiff{User: User{Items: tt.items, PersonalStats: PersonalStats{StatEnergyDrinkUsed: int64(tt.used)}}}
+		if got, exact := diff.CalculateEnergyDrinkEnergy(); got != tt.want || exact != tt.wantExact {
+			t.Errorf("%s: CalculateEnergyDrinkEnergy() = (%d, %t), want (%d, %t)", tt.name, got, exact, tt.want, tt.wantExact)
+		}
+	}
+}
+
+func TestRefillReconciler_Reconcile(t *testing.T) {
+	// The daily flag updates a snapshot before PersonalStats, then a special refill is used
+	diffs := []UserDiff{
+		{User: User{Refills: Refills{EnergyRefillUsed: true}}},
+		{User: User{PersonalStats: PersonalStats{StatRefills: 1}}},
+		{User: User{PersonalStats: PersonalStats{StatRefills: 1}, Refills: Refills{SpecialRefillsAvailable: -1}}},
+		{User: User{PersonalStats: PersonalStats{StatRefills: 1}}},
+	}
+	var reconciler RefillReconciler
+	var total int
+	for _, diff := range diffs {
+		total += reconciler.Reconcile(&diff).Total
+	}
+	if total != 3 {
+		t.Errorf("Reconcile() total = %d, want 3", total)
+	}
+}
+
+func TestRefillStreakTracker(t *testing.T) {
+	var tracker RefillStreakTracker
+	day := time.Date(2019, time.August, 24, 12, 0, 0, 0, time.UTC)
+	used := User{Refills: Refills{EnergyRefillUsed: true}}
+	for _, d := range []int{0, 1, 2, 4, 5} {
+		tracker.Observe(User{}, day.Add(time.Hour*24*time.Duration(d)-time.Hour))
+		tracker.Observe(used, day.Add(time.Hour*24*time.Duration(d)))
+		tracker.Observe(used, day.Add(time.Hour*24*time.Duration(d)+time.Hour))
+	}
+	want := RefillStreak{DaysUsed: 5, CurrentStreak: 2, LongestStreak: 3}
+	if got := tracker.Streak(); got != want {
+		t.Errorf("Streak() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUser_DiffAt_NerveSpent(t *testing.T) {
+	stats := BattleStats{Strength: "1", Speed: "1", Dexterity: "1", Defense: "1"}
+	before := User{BattleStats: stats, Bars: Bars{Nerve: Nerve{Current: 40, Maximum: 60}}}
+	after := User{BattleStats: stats, Bars: Bars{Nerve: Nerve{Current: 10, Maximum: 60}},
+		PersonalStats: PersonalStats{StatNerveRefills: 1}}
+	start := time.Date(2019, time.August, 25, 0, 0, 0, 0, time.UTC)
+	// 40 left + 60 refilled + 6 regen over 30 minutes - 10 remaining
+	diff := before.DiffAt(after, start, start.Add(time.Minute*30))
+	if got := diff.CalculateNerveSpent(); got != 96 {
+		t.Errorf("CalculateNerveSpent() = %d, want 96", got)
+	}
+	// A full bar before a gap regenerates nothing, whenever the crimes happened
+	before.Bars.Nerve.Current = 60
+	diff = before.DiffAt(after, start, start.Add(time.Hour*8))
+	if got := diff.CalculateNerveSpent(); got != 110 || !diff.LowConfidence {
+		t.Errorf("CalculateNerveSpent() = %d, low %t, want 110, true", got, diff.LowConfidence)
+	}
+}
+
+func TestUserDiff_GetEvents_Crimes(t *testing.T) {
+	before := PersonalStats{StatCriminalOffenses: 10, StatTheft: 4, StatDrugDeals: 6}
+	after := PersonalStats{StatCriminalOffenses: 13, StatTheft: 5, StatDrugDeals: 8}
+	diff := UserDiff{User: User{PersonalStats: before.Diff(after), Bars: Bars{Nerve: Nerve{Previous: 30, Current: 18}}}}
+	want := Event{EventCrime, "committed 3 crimes [theft=1, drugs=2] spending 12 nerve"}
+	events := diff.GetEvents()
+	if len(events) == 0 || events[0] != want {
+		t.Errorf("GetEvents() = %v, want %v first", events, want)
+	}
+}
+
+func TestUserDiff_AddToSummary_Busts(t *testing.T) {
+	diff := UserDiff{User: User{PersonalStats: PersonalStats{StatPeopleBusted: 3, StatFailedBusts: 2}}}
+	var summary UserSummary
+	diff.AddToSummary(&summary)
+	// Matches the event, which counts failed attempts too
+	if summary.Busts != 5 || summary.FailedBusts != 2 {
+		t.Errorf("AddToSummary() busts = %d (%d failed), want 5 (2 failed)", summary.Busts, summary.FailedBusts)
+	}
+}
+
+func TestPersonalStats_UnmarshalJSON(t *testing.T) {
+	var ps PersonalStats
+	if err := json.Unmarshal([]byte(`{"xantaken": 19, "networth": 12345678901, "weirdstat": 1.5, "name": "x"}`), &ps); err != nil {
+		t.Fatalf("UnmarshalJSON() = %v", err)
+	}
+	want := PersonalStats{StatXanaxTaken: 19, "networth": 12345678901, "weirdstat": 1}
+	if !reflect.DeepEqual(ps, want) || ps.XanaxTaken() != 19 {
+		t.Errorf("UnmarshalJSON() = %v, want %v", ps, want)
+	}
+}
+
+func TestPersonalStats_Diff(t *testing.T) {
+	before := PersonalStats{StatXanaxTaken: 19, StatRefills: 7, "networth": 100, "gone": 3}
+	after := PersonalStats{StatXanaxTaken: 20, StatRefills: 7, "networth": 250}
+	want := PersonalStats{StatXanaxTaken: 1, "networth": 150, "gone": -3}
+	if got := before.Diff(after); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
+
+func TestUser_Equals_PersonalStats(t *testing.T) {
+	before := User{UserId: 1, PersonalStats: PersonalStats{StatXanaxTaken: 19, "networth": 100}}
+	richer := User{UserId: 1, PersonalStats: PersonalStats{StatXanaxTaken: 19, "networth": 250}}
+	if !before.Equals(richer) {
+		t.Errorf("Equals() = false for a networth change")
+	}
+	xanax := User{UserId: 1, PersonalStats: PersonalStats{StatXanaxTaken: 20, "networth": 100}}
+	if before.Equals(xanax) {
+		t.Errorf("Equals() = true for a xanax change")
+	}
+}
@@ -15,8 +15,11 @@ type RawUser struct {
 	Defense string `json:"defense,omitempty"`
 
 	// Bars
-	Energy Energy `json:"energy,omitempty"`
-	Happy  Happy  `json:"happy,omitempty"`
+	Energy Energy   `json:"energy,omitempty"`
+	Happy  Happy    `json:"happy,omitempty"`
+	Nerve  Nerve    `json:"nerve,omitempty"`
+	Life   Life     `json:"life,omitempty"`
+	Chain  ChainBar `json:"chain,omitempty"`
 
 	// Fields
 	Name string `json:"name,omitempty"`
@@ -46,28 +49,44 @@ const (
 )
 
 type Item struct {
-	Id int `json:"ID,omitempty"` // FHC, EDVD consts above
+	Id int `json:"ID,omitempty"`
+	Name string `json:"name,omitempty"`
 	Quantity int `json:"quantity,omitempty"`
 }
 
+// Items kept from the Torn inventory; FHCs, EDVDs and energy drinks are always kept as
+// energy calculations depend on them
+func IsTrackedItem(id int) bool {
+	if len(ItemAllowlist) == 0 || ItemAllowlist[id] {
+		return true
+	}
+	return id == FHC || id == EDVD || ActiveEnergyRules.IsEnergyDrink(id)
+}
+
 func (i Item) Diff(other Item) Item {
 	return Item{
 		Id: i.Id,
+		Name: i.Name,
 		Quantity: other.Quantity - i.Quantity,
 	}
 }
 
 func CalculateItemDiffs(prev []Item, curr []Item) []Item {
 	items := make(map[int]int)
+	names := make(map[int]string)
 	for _, item := range curr {
 		items[item.Id] = item.Quantity
+		names[item.Id] = item.Name
 	}
 	for _, item := range prev {
 		items[item.Id] -= item.Quantity
+		if names[item.Id] == "" {
+			names[item.Id] = item.Name
+		}
 	}
 	var result []Item
 	for id, quantity := range items {
-		result = append(result, Item{id, quantity})
+		result = append(result, Item{id, names[id], quantity})
 	}
 	sort.SliceStable(result, func(i, j int) bool {
 		return result[i].Id < result[j].Id
@@ -83,13 +102,13 @@ func (u User) Equals(other interface{}) bool {
 		u.BattleStats == other.(User).BattleStats &&
 		u.Bars.Equals(other.(User).Bars) &&
 		Eq(u.Jobs, other.(User).Jobs) &&
-		u.PersonalStats == other.(User).PersonalStats &&
+		u.PersonalStats.Equals(other.(User).PersonalStats) &&
 		u.Refills == other.(User).Refills &&
 		reflect.DeepEqual(u.Items, other.(User).Items)
 }
 
 func (raw RawUser) Bars() Bars {
-	return Bars{raw.Energy, raw.Happy}
+	return Bars{raw.Energy, raw.Happy, raw.Nerve, raw.Life, raw.Chain}
 }
 
 func (raw RawUser) BattleStats() BattleStats {
@@ -125,7 +144,7 @@ func (u *User) UnmarshalJSON(b []byte) error {
 				*u = *innerUser
 				var newItems []Item
 				for _, item := range u.Items {
-					if item.Id == FHC || item.Id == EDVD {
+					if IsTrackedItem(item.Id) {
 						newItems = append(newItems, item)
 					}
 				}
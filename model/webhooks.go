@@ -0,0 +1,63 @@
+package model
+
+import (
+	"errors"
+	"net/url"
+	"time"
+)
+
+type WebhookSubscription struct {
+	Id         string    `json:"id,omitempty"` // assigned on insert
+	Url        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`     // HMAC key payloads are signed with
+	EventTypes []string  `json:"eventTypes,omitempty"` // every event type when empty
+	MinEnergy  int       `json:"minEnergy,omitempty"`  // train events below this are skipped
+	Created    time.Time `json:"created"`
+}
+
+func (s WebhookSubscription) Validate() error {
+	u, err := url.Parse(s.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid url, expected an http or https URL")
+	}
+	if s.Secret == "" {
+		return errors.New("missing secret")
+	}
+	for _, eventType := range s.EventTypes {
+		if !IsEventType(eventType) {
+			return errors.New("invalid event type: " + eventType)
+		}
+	}
+	if s.MinEnergy < 0 {
+		return errors.New("minEnergy can't be negative")
+	}
+	return nil
+}
+
+func (s WebhookSubscription) Matches(eventType string, trained int) bool {
+	if eventType == EventTrain && trained < s.MinEnergy {
+		return false
+	}
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome of sending one event to one subscription, after retries
+type WebhookDelivery struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscriptionId"`
+	EventType      string    `json:"eventType"`
+	UserId         uint      `json:"userId"`
+	Attempts       int       `json:"attempts"`
+	StatusCode     int       `json:"statusCode,omitempty"` // of the last attempt
+	Error          string    `json:"error,omitempty"`
+	Delivered      bool      `json:"delivered"`
+	Time           time.Time `json:"time"` // of the last attempt
+}
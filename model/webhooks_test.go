@@ -0,0 +1,18 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestWebhookSubscription_Matches(t *testing.T) {
+	all := WebhookSubscription{MinEnergy: 100}
+	if !all.Matches(EventOverdose, 0) || !all.Matches(EventTrain, 100) || all.Matches(EventTrain, 99) {
+		t.Errorf("Matches() wrong for subscription to every event type")
+	}
+	if err := (WebhookSubscription{Url: "ftp://example.com", Secret: "s"}).Validate(); err == nil {
+		t.Errorf("Validate() expected an error for a non-http URL")
+	}
+	if err := (WebhookSubscription{Url: "https://example.com", Secret: "s", EventTypes: []string{"xanax-binge"}}).Validate(); err == nil {
+		t.Errorf("Validate() expected an error for an unknown event type")
+	}
+}
@@ -0,0 +1,39 @@
+package rethinkdb
+
+import (
+	r "gopkg.in/rethinkdb/rethinkdb-go.v5"
+	"time"
+	"torn/model"
+)
+
+// Expects a secondary index "timestamp_ended" on the Attack table
+type AttackDao struct {
+	Session *r.Session
+}
+
+func (dao AttackDao) Upsert(attacks []model.Attack) error {
+	if len(attacks) == 0 {
+		return nil
+	}
+	_, err := r.DB("TornEnergy").Table("Attack").
+		Insert(attacks, r.InsertOpts{Conflict: "replace"}).
+		RunWrite(dao.Session)
+	return err
+}
+
+func (dao AttackDao) GetInRange(earliest time.Time, latest time.Time) ([]model.Attack, error) {
+	cursor, err := r.DB("TornEnergy").Table("Attack").
+		Between(earliest.Unix(), latest.Unix(), r.BetweenOpts{LeftBound: "closed", RightBound: "open", Index: "timestamp_ended"}).
+		OrderBy(r.OrderByOpts{Index: "timestamp_ended"}).
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var rows []model.Attack
+	err = cursor.All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
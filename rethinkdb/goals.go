@@ -0,0 +1,90 @@
+package rethinkdb
+
+import (
+	"errors"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v5"
+	"time"
+	"torn/model"
+)
+
+// Expects a secondary index "userId" on the Goal table
+type GoalDao struct {
+	Session *r.Session
+}
+
+// Returns the generated ID
+func (dao GoalDao) Insert(goal model.Goal) (string, error) {
+	goal.Id = ""
+	response, err := r.DB("TornEnergy").Table("Goal").
+		Insert(goal).
+		RunWrite(dao.Session)
+	if err != nil {
+		return "", err
+	}
+	if len(response.GeneratedKeys) < 1 {
+		return "", errors.New("insert didn't generate a key")
+	}
+	return response.GeneratedKeys[0], nil
+}
+
+// Nil when there's no goal with the ID
+func (dao GoalDao) Get(id string) (*model.Goal, error) {
+	cursor, err := r.DB("TornEnergy").Table("Goal").
+		Get(id).
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var goal model.Goal
+	if err := cursor.One(&goal); err == r.ErrEmptyResult {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &goal, nil
+}
+
+func (dao GoalDao) GetForUser(userId uint) ([]model.Goal, error) {
+	cursor, err := r.DB("TornEnergy").Table("Goal").
+		GetAllByIndex("userId", userId).
+		OrderBy("end").
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var goals []model.Goal
+	err = cursor.All(&goals)
+	return goals, err
+}
+
+// Goals not achieved yet
+func (dao GoalDao) GetOpen() ([]model.Goal, error) {
+	cursor, err := r.DB("TornEnergy").Table("Goal").
+		Filter(r.Row.HasFields("achieved").Not()).
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var goals []model.Goal
+	err = cursor.All(&goals)
+	return goals, err
+}
+
+func (dao GoalDao) MarkAchieved(id string, at time.Time) error {
+	_, err := r.DB("TornEnergy").Table("Goal").
+		Get(id).
+		Update(map[string]interface{}{"achieved": at}).
+		RunWrite(dao.Session)
+	return err
+}
+
+func (dao GoalDao) Delete(id string) error {
+	_, err := r.DB("TornEnergy").Table("Goal").
+		Get(id).
+		Delete().
+		RunWrite(dao.Session)
+	return err
+}
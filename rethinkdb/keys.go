@@ -0,0 +1,59 @@
+package rethinkdb
+
+import (
+	r "gopkg.in/rethinkdb/rethinkdb-go.v5"
+	"time"
+)
+
+// API key registered through the Discord bot. Expects a secondary index "discordUserId" on
+// the ApiKey table
+type RegisteredKey struct {
+	UserId        uint      `json:"id"` // Torn user the key belongs to
+	Name          string    `json:"name"`
+	ApiKey        string    `json:"apiKey"`
+	DiscordUserId string    `json:"discordUserId"`
+	Registered    time.Time `json:"registered"`
+}
+
+type KeyDao struct {
+	Session *r.Session
+}
+
+// Replaces the key previously registered for the Torn user
+func (dao KeyDao) Upsert(key RegisteredKey) error {
+	_, err := r.DB("TornEnergy").Table("ApiKey").
+		Insert(key, r.InsertOpts{Conflict: "replace"}).
+		RunWrite(dao.Session)
+	return err
+}
+
+func (dao KeyDao) GetAll() ([]RegisteredKey, error) {
+	cursor, err := r.DB("TornEnergy").Table("ApiKey").
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var keys []RegisteredKey
+	err = cursor.All(&keys)
+	return keys, err
+}
+
+// Nil when the Discord user hasn't registered a key
+func (dao KeyDao) GetByDiscordUser(discordUserId string) (*RegisteredKey, error) {
+	cursor, err := r.DB("TornEnergy").Table("ApiKey").
+		GetAllByIndex("discordUserId", discordUserId).
+		Limit(1).
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var key RegisteredKey
+	if err := cursor.One(&key); err == r.ErrEmptyResult {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -4,16 +4,33 @@ import (
 	"errors"
 	"fmt"
 	r "gopkg.in/rethinkdb/rethinkdb-go.v5"
-	"log"
 	"time"
 	"torn/model"
+	"torn/tlog"
 )
 
+// Keyframes hold the full Document; delta rows only keep Document.UserId (for the
+// userIdTimestamp index) and a merge patch against the user's previous snapshot
 type RethinkTornUser struct {
 	Id        int64      `r:"id"`
 	Offset    int64      `r:"offset"`
 	Timestamp time.Time  `r:"timestamp,omitempty"`
 	Document  model.User `r:"document,omitempty"`
+	Delta     string     `r:"delta,omitempty"`
+	// Set by retention on the snapshot ending an interval it merged
+	Gaps *model.MergedGaps `r:"gaps,omitempty"`
+}
+
+func (u RethinkTornUser) IsKeyframe() bool {
+	return u.Delta == ""
+}
+
+// Diffs this snapshot with the next one, keeping the gap flags of any intervals retention
+// merged in between
+func (u RethinkTornUser) DiffTo(next RethinkTornUser) model.UserDiff {
+	diff := u.Document.DiffAt(next.Document, u.Timestamp, next.Timestamp)
+	diff.MergeGaps(next.Gaps)
+	return diff
 }
 
 type UserDao struct {
@@ -36,9 +53,65 @@ func (dao UserDao) GetUserIds() ([]int64, error) {
 	return rows, nil
 }
 
+// Full snapshots in range, rebuilt from the closest keyframe at or before earliest
 func (dao UserDao) GetInRange(id int64, earliest time.Time, latest time.Time) ([]RethinkTornUser, error) {
+	start := earliest
+	keyframe, err := dao.getKeyframeBefore(id, earliest)
+	if err != nil {
+		return nil, err
+	}
+	if keyframe != nil {
+		start = keyframe.Timestamp
+	}
+	rows, err := dao.GetRawInRange(id, start, latest)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := ReconstructSnapshots(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, snapshot := range snapshots {
+		if !snapshot.Timestamp.Before(earliest) {
+			return snapshots[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+func (dao UserDao) getKeyframeBefore(id int64, t time.Time) (*RethinkTornUser, error) {
+	cursor, err := r.DB("TornEnergy").Table("User").
+		Between([]interface{}{id, r.MinVal}, []interface{}{id, t}, r.BetweenOpts{LeftBound: "closed", RightBound: "closed", Index: "userIdTimestamp"}).
+		OrderBy(r.OrderByOpts{Index: r.Desc("userIdTimestamp")}).
+		Filter(r.Row.HasFields("delta").Not()).
+		Limit(1).
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var row RethinkTornUser
+	err = cursor.One(&row)
+	if err == r.ErrEmptyResult {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// Rows as stored, deltas included
+func (dao UserDao) GetRawInRange(id int64, earliest time.Time, latest time.Time) ([]RethinkTornUser, error) {
+	return dao.getRawBetween(id, earliest, latest)
+}
+
+func (dao UserDao) GetAllRaw(id int64) ([]RethinkTornUser, error) {
+	return dao.getRawBetween(id, r.MinVal, r.MaxVal)
+}
+
+func (dao UserDao) getRawBetween(id int64, lower interface{}, upper interface{}) ([]RethinkTornUser, error) {
 	cursor, err := r.DB("TornEnergy").Table("User").
-		Between([]interface{}{id, earliest}, []interface{}{id, latest}, r.BetweenOpts{LeftBound: "closed", RightBound: "open", Index: "userIdTimestamp"}).
+		Between([]interface{}{id, lower}, []interface{}{id, upper}, r.BetweenOpts{LeftBound: "closed", RightBound: "open", Index: "userIdTimestamp"}).
 		OrderBy(r.OrderByOpts{Index: "userIdTimestamp"}).
 		Run(dao.Session)
 	if err != nil {
@@ -80,6 +153,36 @@ func (dao UserDao) Insert(user RethinkTornUser) error {
 	return nil
 }
 
+func (dao UserDao) Replace(user RethinkTornUser) error {
+	_, err := r.DB("TornEnergy").Table("User").
+		Get(user.Id).
+		Replace(user).
+		RunWrite(dao.Session)
+	return err
+}
+
+func (dao UserDao) Delete(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = id
+	}
+	_, err := r.DB("TornEnergy").Table("User").
+		GetAll(keys...).
+		Delete().
+		RunWrite(dao.Session)
+	return err
+}
+
+func CheckSession(session *r.Session) error {
+	if !session.IsConnected() {
+		return errors.New("RethinkDB session is not connected")
+	}
+	return nil
+}
+
 func SetUpDb(server string) *r.Session {
 	r.SetTags("r", "json")
 	session, err := r.Connect(r.ConnectOpts{
@@ -88,7 +191,7 @@ func SetUpDb(server string) *r.Session {
 		WriteTimeout: time.Second * 30,
 	})
 	if err != nil {
-		log.Fatalln(err)
+		tlog.WithError(err).Fatalf("Unable to connect to RethinkDB")
 	}
 	return session
 }
\ No newline at end of file
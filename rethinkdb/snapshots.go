@@ -0,0 +1,83 @@
+package rethinkdb
+
+import (
+	"sync"
+	"torn/model"
+	"torn/tlog"
+)
+
+// Snapshots between keyframes; bounds how many deltas a read has to replay
+const DefaultKeyframeInterval = 50
+
+// Rebuilds full documents from rows ordered by timestamp. Deltas before the first keyframe
+// can't be rebuilt and are dropped
+func ReconstructSnapshots(rows []RethinkTornUser) ([]RethinkTornUser, error) {
+	var snapshots []RethinkTornUser
+	var prev *model.User
+	for _, row := range rows {
+		if row.IsKeyframe() {
+			document := row.Document
+			prev = &document
+		} else if prev == nil {
+			tlog.WithFields(tlog.Fields{"user_id": row.Document.UserId, "offset": row.Offset}).Warnf("Dropping delta without a keyframe")
+			continue
+		} else {
+			document, err := prev.ApplyDelta(row.Delta)
+			if err != nil {
+				return nil, err
+			}
+			prev = &document
+			row.Document = document
+			row.Delta = ""
+		}
+		snapshots = append(snapshots, row)
+	}
+	return snapshots, nil
+}
+
+// Converts full snapshots into keyframes and deltas before they're stored. Only the last
+// snapshot per user is kept in memory, so the first write for each user after a restart
+// is a keyframe
+type SnapshotWriter struct {
+	KeyframeInterval int
+
+	mux   sync.Mutex
+	users map[uint]*writerState
+}
+
+type writerState struct {
+	last          model.User
+	sinceKeyframe int
+}
+
+func NewSnapshotWriter(keyframeInterval int) *SnapshotWriter {
+	return &SnapshotWriter{KeyframeInterval: keyframeInterval, users: make(map[uint]*writerState)}
+}
+
+// Returns the row to store for user; rows are expected in timestamp order per user
+func (w *SnapshotWriter) Compact(user RethinkTornUser) (RethinkTornUser, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	userId := user.Document.UserId
+	state, found := w.users[userId]
+	if !found || w.KeyframeInterval <= 1 || state.sinceKeyframe+1 >= w.KeyframeInterval {
+		w.users[userId] = &writerState{last: user.Document}
+		return user, nil
+	}
+	delta, err := state.last.Delta(user.Document)
+	if err != nil {
+		return user, err
+	}
+	state.last = user.Document
+	state.sinceKeyframe += 1
+	user.Delta = delta
+	user.Document = model.User{UserId: userId}
+	return user, nil
+}
+
+// Forgets the user so their next snapshot is a keyframe, e.g. after a failed insert
+func (w *SnapshotWriter) Reset(userId uint) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	delete(w.users, userId)
+}
@@ -0,0 +1,70 @@
+package rethinkdb
+
+import (
+	"errors"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v5"
+	"torn/model"
+)
+
+// Expects a secondary index "subscriptionId" on the WebhookDelivery table
+type WebhookDao struct {
+	Session *r.Session
+}
+
+// Returns the generated ID
+func (dao WebhookDao) Insert(subscription model.WebhookSubscription) (string, error) {
+	subscription.Id = ""
+	response, err := r.DB("TornEnergy").Table("Webhook").
+		Insert(subscription).
+		RunWrite(dao.Session)
+	if err != nil {
+		return "", err
+	}
+	if len(response.GeneratedKeys) < 1 {
+		return "", errors.New("insert didn't generate a key")
+	}
+	return response.GeneratedKeys[0], nil
+}
+
+func (dao WebhookDao) GetSubscriptions() ([]model.WebhookSubscription, error) {
+	cursor, err := r.DB("TornEnergy").Table("Webhook").
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var subscriptions []model.WebhookSubscription
+	err = cursor.All(&subscriptions)
+	return subscriptions, err
+}
+
+func (dao WebhookDao) Delete(id string) error {
+	_, err := r.DB("TornEnergy").Table("Webhook").
+		Get(id).
+		Delete().
+		RunWrite(dao.Session)
+	return err
+}
+
+func (dao WebhookDao) LogDelivery(delivery model.WebhookDelivery) error {
+	_, err := r.DB("TornEnergy").Table("WebhookDelivery").
+		Insert(delivery, r.InsertOpts{Conflict: "replace"}).
+		RunWrite(dao.Session)
+	return err
+}
+
+// Most recent first
+func (dao WebhookDao) GetDeliveries(subscriptionId string, limit int) ([]model.WebhookDelivery, error) {
+	cursor, err := r.DB("TornEnergy").Table("WebhookDelivery").
+		GetAllByIndex("subscriptionId", subscriptionId).
+		OrderBy(r.Desc("time")).
+		Limit(limit).
+		Run(dao.Session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var deliveries []model.WebhookDelivery
+	err = cursor.All(&deliveries)
+	return deliveries, err
+}
@@ -0,0 +1,220 @@
+package talert
+
+import (
+	"sync"
+	"time"
+	"torn/tlog"
+)
+
+// Signal sources recorded by the producer
+const (
+	SourceTorn          = "torn"
+	SourceKafkaDelivery = "kafka-delivery"
+	SourcePoll          = "poll" // polls that published their User or found it unchanged
+)
+
+// How long outcomes are kept for error rate rules
+const Retention = time.Hour
+
+type Args struct {
+	DiscordWebhook string
+	SlackWebhook   string
+	Webhook        string
+	Stdout         bool
+	StaleAfter     time.Duration
+}
+
+type Alert struct {
+	Rule     string    `json:"rule"`
+	Key      string    `json:"key"` // unique per firing alert, used to dedupe
+	Message  string    `json:"message"`
+	Resolved bool      `json:"resolved"`
+	Time     time.Time `json:"time"`
+}
+
+type outcome struct {
+	at time.Time
+	ok bool
+}
+
+type Stats struct {
+	mux         sync.Mutex
+	started     time.Time
+	outcomes    map[string][]outcome
+	lastSuccess map[string]time.Time
+	removedKeys map[string]string
+}
+
+func NewStats(now time.Time) *Stats {
+	return &Stats{
+		started:     now,
+		outcomes:    make(map[string][]outcome),
+		lastSuccess: make(map[string]time.Time),
+		removedKeys: make(map[string]string),
+	}
+}
+
+func (s *Stats) Record(source string, ok bool, at time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	outcomes := s.outcomes[source]
+	for len(outcomes) > 0 && at.Sub(outcomes[0].at) > Retention {
+		outcomes = outcomes[1:]
+	}
+	s.outcomes[source] = append(outcomes, outcome{at, ok})
+	if ok {
+		s.lastSuccess[source] = at
+	}
+}
+
+func (s *Stats) RemoveKey(key string, reason string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.removedKeys[key] = reason
+}
+
+func (s *Stats) Outcomes(source string, since time.Time) (ok int, failed int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, o := range s.outcomes[source] {
+		if o.at.Before(since) {
+			continue
+		}
+		if o.ok {
+			ok += 1
+		} else {
+			failed += 1
+		}
+	}
+	return ok, failed
+}
+
+// Falls back to when stats started being collected
+func (s *Stats) LastSuccess(source string) time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if last, found := s.lastSuccess[source]; found {
+		return last
+	}
+	return s.started
+}
+
+func (s *Stats) RemovedKeys() map[string]string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	removed := make(map[string]string)
+	for k, v := range s.removedKeys {
+		removed[k] = v
+	}
+	return removed
+}
+
+// Evaluates rules against recorded stats, notifying when alerts fire and resolve
+type Engine struct {
+	Rules     []Rule
+	Notifiers []Notifier
+	Stats     *Stats
+	mux       sync.Mutex
+	firing    map[string]Alert
+}
+
+func NewEngine(rules []Rule, notifiers []Notifier) *Engine {
+	return &Engine{
+		Rules:     rules,
+		Notifiers: notifiers,
+		Stats:     NewStats(time.Now()),
+		firing:    make(map[string]Alert),
+	}
+}
+
+func NewEngineFromArgs(args Args) *Engine {
+	return NewEngine(DefaultRules(args.StaleAfter), NewNotifiersFromArgs(args))
+}
+
+func NewNotifiersFromArgs(args Args) []Notifier {
+	var notifiers []Notifier
+	if args.Stdout {
+		notifiers = append(notifiers, NewStdoutNotifier())
+	}
+	if args.DiscordWebhook != "" {
+		notifiers = append(notifiers, NewDiscordNotifier(args.DiscordWebhook))
+	}
+	if args.SlackWebhook != "" {
+		notifiers = append(notifiers, NewSlackNotifier(args.SlackWebhook))
+	}
+	if args.Webhook != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(args.Webhook))
+	}
+	return notifiers
+}
+
+// Nil-safe so callers can run without alerting
+func (e *Engine) Record(source string, ok bool) {
+	if e == nil {
+		return
+	}
+	e.Stats.Record(source, ok, time.Now())
+}
+
+func (e *Engine) RemoveKey(key string, reason string) {
+	if e == nil {
+		return
+	}
+	e.Stats.RemoveKey(key, reason)
+}
+
+func (e *Engine) Evaluate(now time.Time) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	current := make(map[string]Alert)
+	for _, rule := range e.Rules {
+		for _, alert := range rule.Evaluate(now, e.Stats) {
+			alert.Time = now
+			current[alert.Key] = alert
+		}
+	}
+	for key, alert := range current {
+		if _, alreadyFiring := e.firing[key]; !alreadyFiring {
+			e.firing[key] = alert
+			e.notify(alert)
+		}
+	}
+	for key, alert := range e.firing {
+		if _, stillFiring := current[key]; !stillFiring {
+			delete(e.firing, key)
+			alert.Resolved = true
+			alert.Time = now
+			e.notify(alert)
+		}
+	}
+}
+
+func (e *Engine) Firing() []Alert {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	var alerts []Alert
+	for _, alert := range e.firing {
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func (e *Engine) notify(alert Alert) {
+	for _, n := range e.Notifiers {
+		if err := n.Notify(alert); err != nil {
+			tlog.WithError(err).WithField("rule", alert.Rule).Errorf("Unable to send alert")
+		}
+	}
+}
+
+func (e *Engine) Run(interval time.Duration) {
+	if e == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for t := range ticker.C {
+			e.Evaluate(t)
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+package talert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stub struct {
+	mux    sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func (s *stub) handler(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mux.Lock()
+	s.bodies = append(s.bodies, body)
+	s.mux.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	discord := &stub{}
+	discordServer := httptest.NewServer(http.HandlerFunc(discord.handler))
+	defer discordServer.Close()
+	webhook := &stub{}
+	webhookServer := httptest.NewServer(http.HandlerFunc(webhook.handler))
+	defer webhookServer.Close()
+
+	rule := ErrorRateRule{Name: "torn-api-down", Source: SourceTorn, Description: "Torn API down",
+		Window: time.Minute, MaxErrorRate: 0.5, MinSamples: 2}
+	engine := NewEngine([]Rule{rule}, []Notifier{NewDiscordNotifier(discordServer.URL), NewWebhookNotifier(webhookServer.URL)})
+	now := time.Now()
+	engine.Stats.Record(SourceTorn, false, now)
+	engine.Stats.Record(SourceTorn, false, now)
+	engine.Evaluate(now)
+	// Still firing, so no duplicate notification
+	engine.Evaluate(now.Add(time.Second))
+	if len(discord.bodies) != 1 {
+		t.Fatalf("Evaluate() sent %d Discord notifications, want 1", len(discord.bodies))
+	}
+	if content := discord.bodies[0]["content"].(string); !strings.HasPrefix(content, "[FIRING] Torn API down: 2/2 failed") {
+		t.Errorf("Evaluate() Discord content = %s", content)
+	}
+
+	// Errors age out of the window and the alert resolves
+	engine.Evaluate(now.Add(time.Minute * 2))
+	if len(webhook.bodies) != 2 {
+		t.Fatalf("Evaluate() sent %d webhook notifications, want 2", len(webhook.bodies))
+	}
+	if webhook.bodies[0]["resolved"] != false || webhook.bodies[1]["resolved"] != true || webhook.bodies[1]["rule"] != "torn-api-down" {
+		t.Errorf("Evaluate() webhook bodies = %v", webhook.bodies)
+	}
+	if len(engine.Firing()) != 0 {
+		t.Errorf("Firing() = %v, want none", engine.Firing())
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	now := time.Now()
+	stats := NewStats(now)
+	stats.Record(SourceKafkaDelivery, true, now)
+	stats.RemoveKey("abcd", "IncorrectKey")
+	var fired []string
+	for _, rule := range DefaultRules(time.Second * 30) {
+		for _, alert := range rule.Evaluate(now.Add(time.Second*45), stats) {
+			fired = append(fired, alert.Key)
+		}
+	}
+	if strings.Join(fired, ",") != "kafka-stale,key-removed:abcd" {
+		t.Errorf("DefaultRules() fired %v", fired)
+	}
+	// A poll that found its User unchanged keeps the pipeline fresh without publishing
+	stats.Record(SourcePoll, true, now.Add(time.Second*40))
+	for _, rule := range DefaultRules(time.Second * 30) {
+		for _, alert := range rule.Evaluate(now.Add(time.Second*45), stats) {
+			if alert.Rule == "kafka-stale" {
+				t.Errorf("DefaultRules() fired %v after a recent poll", alert.Key)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package talert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+func FormatAlert(alert Alert) string {
+	if alert.Resolved {
+		return fmt.Sprintf("[RESOLVED] %s (%s)", alert.Message, alert.Rule)
+	}
+	return fmt.Sprintf("[FIRING] %s (%s)", alert.Message, alert.Rule)
+}
+
+type StdoutNotifier struct {
+	Writer io.Writer
+}
+
+func NewStdoutNotifier() *StdoutNotifier {
+	return &StdoutNotifier{os.Stdout}
+}
+
+func (n StdoutNotifier) Notify(alert Alert) error {
+	_, err := fmt.Fprintf(n.Writer, "%s ALERT %s\n", alert.Time.Format(time.RFC3339), FormatAlert(alert))
+	return err
+}
+
+// POSTs a JSON body built from each alert
+type WebhookNotifier struct {
+	Url     string
+	Client  *http.Client
+	Payload func(alert Alert) interface{}
+}
+
+func newWebhookNotifier(url string, payload func(alert Alert) interface{}) *WebhookNotifier {
+	return &WebhookNotifier{
+		Url:     url,
+		Client:  &http.Client{Timeout: time.Second * 10},
+		Payload: payload,
+	}
+}
+
+// Sends the Alert itself
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return newWebhookNotifier(url, func(alert Alert) interface{} {
+		return alert
+	})
+}
+
+func NewDiscordNotifier(url string) *WebhookNotifier {
+	return newWebhookNotifier(url, func(alert Alert) interface{} {
+		return map[string]string{"content": FormatAlert(alert)}
+	})
+}
+
+func NewSlackNotifier(url string) *WebhookNotifier {
+	return newWebhookNotifier(url, func(alert Alert) interface{} {
+		return map[string]string{"text": FormatAlert(alert)}
+	})
+}
+
+func (n WebhookNotifier) Notify(alert Alert) error {
+	body, err := json.Marshal(n.Payload(alert))
+	if err != nil {
+		return err
+	}
+	resp, err := n.Client.Post(n.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected webhook response: %s", resp.Status)
+	}
+	return nil
+}
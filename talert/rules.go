@@ -0,0 +1,109 @@
+package talert
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Rule interface {
+	Evaluate(now time.Time, stats *Stats) []Alert
+}
+
+// Fires when at least MaxErrorRate of a source's outcomes within Window failed
+type ErrorRateRule struct {
+	Name         string
+	Source       string
+	Description  string
+	Window       time.Duration
+	MaxErrorRate float64
+	MinSamples   int
+}
+
+func (r ErrorRateRule) Evaluate(now time.Time, stats *Stats) []Alert {
+	ok, failed := stats.Outcomes(r.Source, now.Add(-r.Window))
+	total := ok + failed
+	if total == 0 || total < r.MinSamples {
+		return nil
+	}
+	if float64(failed)/float64(total) < r.MaxErrorRate {
+		return nil
+	}
+	return []Alert{{
+		Rule:    r.Name,
+		Key:     r.Name,
+		Message: fmt.Sprintf("%s: %d/%d failed in the last %s", r.Description, failed, total, r.Window),
+	}}
+}
+
+// Fires when a source hasn't succeeded for MaxAge
+type StalenessRule struct {
+	Name        string
+	Source      string
+	Description string
+	MaxAge      time.Duration
+}
+
+func (r StalenessRule) Evaluate(now time.Time, stats *Stats) []Alert {
+	since := now.Sub(stats.LastSuccess(r.Source))
+	if since < r.MaxAge {
+		return nil
+	}
+	return []Alert{{
+		Rule:    r.Name,
+		Key:     r.Name,
+		Message: fmt.Sprintf("%s in %s", r.Description, since.Round(time.Second)),
+	}}
+}
+
+// Fires once per API key removed from the pool; keys are identified by tlog.KeyFingerprint
+type KeyRemovalRule struct {
+	Name string
+}
+
+func (r KeyRemovalRule) Evaluate(now time.Time, stats *Stats) []Alert {
+	removed := stats.RemovedKeys()
+	var keys []string
+	for key := range removed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var alerts []Alert
+	for _, key := range keys {
+		alerts = append(alerts, Alert{
+			Rule:    r.Name,
+			Key:     r.Name + ":" + key,
+			Message: fmt.Sprintf("API key %s removed from pool: %s", key, removed[key]),
+		})
+	}
+	return alerts
+}
+
+func DefaultRules(staleAfter time.Duration) []Rule {
+	return []Rule{
+		ErrorRateRule{
+			Name:         "torn-api-down",
+			Source:       SourceTorn,
+			Description:  "Torn API down",
+			Window:       time.Minute,
+			MaxErrorRate: 0.9,
+			MinSamples:   5,
+		},
+		ErrorRateRule{
+			Name:         "kafka-publish-failing",
+			Source:       SourceKafkaDelivery,
+			Description:  "Unable to publish to Kafka",
+			Window:       time.Minute,
+			MaxErrorRate: 0.5,
+			MinSamples:   3,
+		},
+		StalenessRule{
+			// Quiet users aren't republished, so unchanged polls count as fresh too
+			Name:        "kafka-stale",
+			Source:      SourcePoll,
+			Description: "Haven't published or confirmed an unchanged User",
+			MaxAge:      staleAfter,
+		},
+		KeyRemovalRule{Name: "key-removed"},
+	}
+}
@@ -0,0 +1,166 @@
+package tcompact
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+	"torn/rethinkdb"
+	"torn/tlog"
+)
+
+type Args struct {
+	RethinkdbServer  string
+	KeyframeInterval int
+	DryRun           bool // measure savings without rewriting rows
+}
+
+type Stats struct {
+	Users       int
+	Rows        int
+	Keyframes   int
+	Deltas      int
+	Rewritten   int
+	BytesBefore int64
+	BytesAfter  int64
+}
+
+func (s *Stats) Add(other Stats) {
+	s.Users += other.Users
+	s.Rows += other.Rows
+	s.Keyframes += other.Keyframes
+	s.Deltas += other.Deltas
+	s.Rewritten += other.Rewritten
+	s.BytesBefore += other.BytesBefore
+	s.BytesAfter += other.BytesAfter
+}
+
+// Percentage of bytes saved
+func (s Stats) Savings() float64 {
+	if s.BytesBefore == 0 {
+		return 0
+	}
+	return 100 * float64(s.BytesBefore-s.BytesAfter) / float64(s.BytesBefore)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("users=%d, rows=%d (keyframes=%d, deltas=%d, rewritten=%d), bytes %d -> %d (%.1f%% saved)",
+		s.Users, s.Rows, s.Keyframes, s.Deltas, s.Rewritten, s.BytesBefore, s.BytesAfter, s.Savings())
+}
+
+// Approximates the stored size by the row's JSON encoding
+func storedSize(row rethinkdb.RethinkTornUser) int64 {
+	b, _ := json.Marshal(row)
+	return int64(len(b))
+}
+
+// Rewrites one user's history as keyframes and deltas; rows already in the target form are
+// left alone, so the conversion can be rerun or interrupted safely
+func CompactUser(dao rethinkdb.UserDao, userId int64, keyframeInterval int, dryRun bool) (Stats, error) {
+	stats := Stats{Users: 1}
+	rows, err := dao.GetAllRaw(userId)
+	if err != nil {
+		return stats, err
+	}
+	snapshots, err := rethinkdb.ReconstructSnapshots(rows)
+	if err != nil {
+		return stats, err
+	}
+	stored := make(map[int64]rethinkdb.RethinkTornUser, len(rows))
+	for _, row := range rows {
+		stored[row.Id] = row
+		stats.BytesBefore += storedSize(row)
+	}
+	err = encode(dao, snapshots, stored, keyframeInterval, dryRun, &stats)
+	return stats, err
+}
+
+// Re-encodes full snapshots as keyframes and deltas, replacing the stored rows whose
+// encoding changed
+func encode(dao rethinkdb.UserDao, snapshots []rethinkdb.RethinkTornUser, stored map[int64]rethinkdb.RethinkTornUser,
+	keyframeInterval int, dryRun bool, stats *Stats) error {
+	rows, err := encodeRows(snapshots, keyframeInterval, nil)
+	if err != nil {
+		return err
+	}
+	return replaceChanged(dao, rows, stored, dryRun, stats)
+}
+
+// Encodes full snapshots as keyframes and deltas; snapshots whose IDs are in keyframes are
+// always stored in full
+func encodeRows(snapshots []rethinkdb.RethinkTornUser, keyframeInterval int, keyframes map[int64]bool) ([]rethinkdb.RethinkTornUser, error) {
+	writer := rethinkdb.NewSnapshotWriter(keyframeInterval)
+	rows := make([]rethinkdb.RethinkTornUser, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if keyframes[snapshot.Id] {
+			writer.Reset(snapshot.Document.UserId)
+		}
+		row, err := writer.Compact(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func replaceChanged(dao rethinkdb.UserDao, rows []rethinkdb.RethinkTornUser, stored map[int64]rethinkdb.RethinkTornUser,
+	dryRun bool, stats *Stats) error {
+	for _, row := range rows {
+		stats.Rows += 1
+		if row.IsKeyframe() {
+			stats.Keyframes += 1
+		} else {
+			stats.Deltas += 1
+		}
+		stats.BytesAfter += storedSize(row)
+		if current := stored[row.Id]; current.IsKeyframe() == row.IsKeyframe() && current.Delta == row.Delta &&
+			reflect.DeepEqual(current.Gaps, row.Gaps) {
+			continue
+		}
+		stats.Rewritten += 1
+		if !dryRun {
+			if err := dao.Replace(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func stopping(done chan bool) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func RunCompaction(args Args, done chan bool) {
+	session := rethinkdb.SetUpDb(args.RethinkdbServer)
+	defer session.Close()
+	dao := rethinkdb.UserDao{Session: session}
+
+	start := time.Now()
+	userIds, err := dao.GetUserIds()
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to get User IDs")
+		return
+	}
+	var total Stats
+	for _, userId := range userIds {
+		if stopping(done) {
+			tlog.Warnf("Compaction interrupted: %s", total)
+			return
+		}
+		stats, err := CompactUser(dao, userId, args.KeyframeInterval, args.DryRun)
+		if err != nil {
+			tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to compact User")
+			continue
+		}
+		tlog.WithField("user_id", userId).Infof("Compacted User: %s", stats)
+		total.Add(stats)
+	}
+	fmt.Printf("Compaction finished in %s (dry run: %t): %s\n", time.Since(start).Round(time.Second), args.DryRun, total)
+}
@@ -0,0 +1,267 @@
+package tcompact
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+	"torn/tlog"
+)
+
+type RetentionArgs struct {
+	RethinkdbServer  string
+	KeyframeInterval int
+	MaxAge           time.Duration // snapshots older than this are downsampled
+	Resolution       time.Duration // one snapshot is kept per interval of old history (none when 0)
+	Edges            []time.Time   // competition boundaries whose neighbouring snapshots are kept
+	ArchiveDir       string
+	DryRun           bool
+}
+
+type RetentionStats struct {
+	Stats
+	Old      int // snapshots older than the cutoff
+	Pruned   int
+	Archived int64 // compressed bytes written to the archive
+}
+
+func (s *RetentionStats) Add(other RetentionStats) {
+	s.Stats.Add(other.Stats)
+	s.Old += other.Old
+	s.Pruned += other.Pruned
+}
+
+func (s RetentionStats) String() string {
+	return fmt.Sprintf("users=%d, old snapshots=%d, pruned=%d, kept=%d, archived=%d bytes, rewritten=%d, stored bytes %d -> %d (%.1f%% saved)",
+		s.Users, s.Old, s.Pruned, s.Old-s.Pruned, s.Archived, s.Rewritten, s.BytesBefore, s.BytesAfter, s.Savings())
+}
+
+// Marks the snapshots to keep. Newer snapshots are always kept, as are the first and last
+// snapshot, both snapshots of any diff with events (so computed results don't change), the
+// snapshots either side of each edge and the first snapshot of each resolution interval
+func SelectRetained(snapshots []rethinkdb.RethinkTornUser, cutoff time.Time, edges []time.Time, resolution time.Duration) []bool {
+	keep := make([]bool, len(snapshots))
+	if len(snapshots) == 0 {
+		return keep
+	}
+	keep[0] = true
+	keep[len(keep)-1] = true
+	for i, snapshot := range snapshots {
+		if !snapshot.Timestamp.Before(cutoff) {
+			keep[i] = true
+		}
+		if resolution > 0 && i > 0 &&
+			!snapshot.Timestamp.Truncate(resolution).Equal(snapshots[i-1].Timestamp.Truncate(resolution)) {
+			keep[i] = true
+		}
+	}
+	for i := 0; i < len(snapshots)-1; i++ {
+		prev, next := snapshots[i], snapshots[i+1]
+		for _, edge := range edges {
+			if prev.Timestamp.Before(edge) && !next.Timestamp.Before(edge) {
+				keep[i] = true
+				keep[i+1] = true
+			}
+		}
+		if keep[i] && keep[i+1] {
+			continue
+		}
+		diff := prev.Document.DiffAt(next.Document, prev.Timestamp, next.Timestamp)
+		if len(diff.GetEvents()) > 0 {
+			keep[i] = true
+			keep[i+1] = true
+		}
+	}
+	return keep
+}
+
+// Gzipped JSON lines of full snapshots, created by the first write so runs that prune
+// nothing don't leave an empty archive behind
+type archive struct {
+	path    string
+	file    *os.File
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+}
+
+func newArchive(dir string, now time.Time) *archive {
+	return &archive{path: filepath.Join(dir, fmt.Sprintf("users-%s.jsonl.gz", now.UTC().Format("20060102T150405Z")))}
+}
+
+func (a *archive) create() error {
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(a.path)
+	if err != nil {
+		return err
+	}
+	a.file = file
+	a.gzip = gzip.NewWriter(file)
+	a.encoder = json.NewEncoder(a.gzip)
+	return nil
+}
+
+func (a *archive) Write(snapshots []rethinkdb.RethinkTornUser) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	if a.file == nil {
+		if err := a.create(); err != nil {
+			return err
+		}
+	}
+	for _, snapshot := range snapshots {
+		if err := a.encoder.Encode(snapshot); err != nil {
+			return err
+		}
+	}
+	// Rows are only deleted once they're on disk
+	if err := a.gzip.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *archive) Created() bool {
+	return a.file != nil
+}
+
+func (a *archive) Size() int64 {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (a *archive) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	if err := a.gzip.Close(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
+
+// Rows for the kept snapshots. The first kept snapshot after each pruned run becomes a
+// keyframe and every other row is a delta against the kept snapshot before it, which is
+// also its predecessor while the pruned rows still exist. Rows can therefore be rewritten
+// before the pruned rows are deleted, and history reads correctly at every step. The
+// keyframes also carry the gap flags of the intervals merged into the one they end
+func retainedRows(snapshots []rethinkdb.RethinkTornUser, keep []bool, keyframeInterval int) ([]rethinkdb.RethinkTornUser, error) {
+	var kept []rethinkdb.RethinkTornUser
+	keyframes := make(map[int64]bool)
+	var gaps model.MergedGaps
+	for i, snapshot := range snapshots {
+		if i > 0 {
+			gaps.Add(snapshots[i-1].DiffTo(snapshot))
+		}
+		if !keep[i] {
+			continue
+		}
+		if i > 0 && !keep[i-1] {
+			keyframes[snapshot.Id] = true
+			merged := gaps
+			snapshot.Gaps = &merged
+		}
+		gaps = model.MergedGaps{}
+		kept = append(kept, snapshot)
+	}
+	return encodeRows(kept, keyframeInterval, keyframes)
+}
+
+// Prunes one user's old snapshots. The kept rows are rewritten first so none depend on a
+// pruned row, then the pruned snapshots are archived and deleted
+func PruneUser(dao rethinkdb.UserDao, userId int64, args RetentionArgs, cutoff time.Time, out *archive) (RetentionStats, error) {
+	stats := RetentionStats{Stats: Stats{Users: 1}}
+	rows, err := dao.GetAllRaw(userId)
+	if err != nil {
+		return stats, err
+	}
+	snapshots, err := rethinkdb.ReconstructSnapshots(rows)
+	if err != nil {
+		return stats, err
+	}
+	stored := make(map[int64]rethinkdb.RethinkTornUser, len(rows))
+	for _, row := range rows {
+		stored[row.Id] = row
+		stats.BytesBefore += storedSize(row)
+	}
+	keep := SelectRetained(snapshots, cutoff, args.Edges, args.Resolution)
+	var pruned []rethinkdb.RethinkTornUser
+	var prunedIds []int64
+	for i, retained := range keep {
+		if snapshots[i].Timestamp.Before(cutoff) {
+			stats.Old += 1
+		}
+		if !retained {
+			pruned = append(pruned, snapshots[i])
+			prunedIds = append(prunedIds, snapshots[i].Id)
+		}
+	}
+	stats.Pruned = len(pruned)
+	if len(pruned) == 0 {
+		return stats, encode(dao, snapshots, stored, args.KeyframeInterval, true, &stats.Stats)
+	}
+	kept, err := retainedRows(snapshots, keep, args.KeyframeInterval)
+	if err != nil {
+		return stats, err
+	}
+	if err := replaceChanged(dao, kept, stored, args.DryRun, &stats.Stats); err != nil {
+		return stats, err
+	}
+	if args.DryRun {
+		return stats, nil
+	}
+	if err := out.Write(pruned); err != nil {
+		return stats, err
+	}
+	return stats, dao.Delete(prunedIds)
+}
+
+func RunRetention(args RetentionArgs, done chan bool) {
+	session := rethinkdb.SetUpDb(args.RethinkdbServer)
+	defer session.Close()
+	dao := rethinkdb.UserDao{Session: session}
+
+	start := time.Now()
+	cutoff := start.Add(-args.MaxAge)
+	var out *archive
+	if !args.DryRun {
+		out = newArchive(args.ArchiveDir, start)
+	}
+	userIds, err := dao.GetUserIds()
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to get User IDs")
+		return
+	}
+	var total RetentionStats
+	for _, userId := range userIds {
+		if stopping(done) {
+			tlog.Warnf("Retention interrupted: %s", total)
+			break
+		}
+		stats, err := PruneUser(dao, userId, args, cutoff, out)
+		if err != nil {
+			tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to prune User")
+			continue
+		}
+		tlog.WithField("user_id", userId).Infof("Pruned User: %s", stats)
+		total.Add(stats)
+	}
+	if out != nil && out.Created() {
+		if err := out.Close(); err != nil {
+			tlog.WithError(err).Errorf("Unable to close archive")
+		}
+		total.Archived = out.Size()
+		tlog.WithField("path", out.path).Infof("Archived pruned snapshots")
+	}
+	fmt.Printf("Retention finished in %s (cutoff: %s, dry run: %t): %s\n",
+		time.Since(start).Round(time.Second), cutoff.Format(time.RFC3339), args.DryRun, total)
+}
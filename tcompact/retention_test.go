@@ -0,0 +1,209 @@
+package tcompact
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+)
+
+func snapshotAt(t time.Time, strength string) rethinkdb.RethinkTornUser {
+	return rethinkdb.RethinkTornUser{
+		Timestamp: t,
+		Document: model.User{UserId: 1, BattleStats: model.BattleStats{Strength: strength, Speed: "1", Dexterity: "1", Defense: "1"},
+			Bars: model.Bars{Energy: model.Energy{Current: 150, Maximum: 150}}},
+	}
+}
+
+func TestSelectRetained(t *testing.T) {
+	base := time.Date(2019, time.August, 30, 22, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	snapshots := []rethinkdb.RethinkTornUser{
+		snapshotAt(at(0), "1"),
+		snapshotAt(at(10), "1"),
+		snapshotAt(at(20), "1"),
+		snapshotAt(at(30), "2"), // trained
+		snapshotAt(at(40), "2"),
+		snapshotAt(at(50), "2"),
+		snapshotAt(at(110), "2"),
+		snapshotAt(at(120), "2"), // competition edge at 00:00
+		snapshotAt(at(130), "2"),
+		snapshotAt(at(140), "2"), // newer than the cutoff
+		snapshotAt(at(150), "2"),
+	}
+	edges := []time.Time{time.Date(2019, time.August, 31, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name       string
+		resolution time.Duration
+		want       []bool
+	}{
+		{"edges and events", 0, []bool{true, false, true, true, false, false, true, true, false, true, true}},
+		{"hourly", time.Hour, []bool{true, false, true, true, false, false, true, true, false, true, true}},
+		{"quarter hourly", time.Minute * 15, []bool{true, false, true, true, false, true, true, true, false, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SelectRetained(snapshots, at(140), edges, tt.resolution); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectRetained() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Reads history at every step of a prune: after each rewritten row and after the delete
+func TestRetainedRows_AcrossPrunedGap(t *testing.T) {
+	base := time.Date(2019, time.August, 30, 22, 0, 0, 0, time.UTC)
+	keep := []bool{true, true, false, false, true, true, false, true, true, true}
+	var snapshots []rethinkdb.RethinkTornUser
+	for i := 0; i < 10; i++ {
+		snapshot := snapshotAt(base.Add(time.Duration(i)*time.Minute), strconv.Itoa(100+i))
+		snapshot.Id = int64(i + 1)
+		if !keep[i] {
+			// Reverted by the next kept snapshot, so a delta against the wrong base shows
+			snapshot.Document.BattleStats.Speed = "5"
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	stored, err := encodeRows(snapshots, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := retainedRows(snapshots, keep, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(table map[int64]rethinkdb.RethinkTornUser) []string {
+		var rows []rethinkdb.RethinkTornUser
+		for _, snapshot := range snapshots {
+			if row, found := table[snapshot.Id]; found {
+				rows = append(rows, row)
+			}
+		}
+		reconstructed, err := rethinkdb.ReconstructSnapshots(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var strengths []string
+		for _, snapshot := range reconstructed {
+			strengths = append(strengths, snapshot.Document.BattleStats.Strength+"/"+snapshot.Document.BattleStats.Speed)
+		}
+		return strengths
+	}
+	var all, retained []string
+	for i, snapshot := range snapshots {
+		stats := snapshot.Document.BattleStats.Strength + "/" + snapshot.Document.BattleStats.Speed
+		all = append(all, stats)
+		if keep[i] {
+			retained = append(retained, stats)
+		}
+	}
+	table := make(map[int64]rethinkdb.RethinkTornUser)
+	for _, row := range stored {
+		table[row.Id] = row
+	}
+	for _, row := range kept {
+		table[row.Id] = row
+		if got := read(table); !reflect.DeepEqual(got, all) {
+			t.Fatalf("after rewriting row %d, history = %v, want %v", row.Id, got, all)
+		}
+	}
+	for i, snapshot := range snapshots {
+		if !keep[i] {
+			delete(table, snapshot.Id)
+		}
+	}
+	if got := read(table); !reflect.DeepEqual(got, retained) {
+		t.Errorf("after deleting pruned rows, history = %v, want %v", got, retained)
+	}
+	for i, row := range kept {
+		if want := i == 0 || row.Id == 5 || row.Id == 8; row.IsKeyframe() != want {
+			t.Errorf("row %d keyframe = %v, want %v", row.Id, row.IsKeyframe(), want)
+		}
+	}
+}
+
+func gapSummary(snapshots []rethinkdb.RethinkTornUser) model.UserSummary {
+	var summary model.UserSummary
+	for i := 0; i < len(snapshots)-1; i++ {
+		snapshots[i].DiffTo(snapshots[i+1]).AddToSummary(&summary)
+	}
+	return summary
+}
+
+func TestRetainedRows_KeepsGapFlags(t *testing.T) {
+	base := time.Date(2019, time.August, 30, 22, 0, 0, 0, time.UTC)
+	keep := []bool{true, false, false, true, true}
+	var snapshots []rethinkdb.RethinkTornUser
+	for i := 0; i < 5; i++ {
+		// Half an hour apart below max energy, so every interval is a data gap
+		snapshot := snapshotAt(base.Add(time.Duration(i)*time.Minute*30), "100")
+		snapshot.Id = int64(i + 1)
+		snapshot.Document.Bars.Energy.Current = 100
+		snapshots = append(snapshots, snapshot)
+	}
+	// Trained in a gap, which is low confidence
+	snapshots[2].Document.BattleStats.Strength = "200"
+	snapshots[3].Document.BattleStats.Strength = "200"
+	snapshots[2].Document.Bars.Energy.Current = 50
+	snapshots[3].Document.Bars.Energy.Current = 50
+	snapshots[4].Document.Bars.Energy.Current = 50
+
+	want := gapSummary(snapshots)
+	if want.DataGaps != 4 || want.LowConfidence != 1 || want.LongestGap != time.Minute*30 {
+		t.Fatalf("original gaps = %d, %d, %s", want.DataGaps, want.LowConfidence, want.LongestGap)
+	}
+	kept, err := retainedRows(snapshots, keep, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconstructed, err := rethinkdb.ReconstructSnapshots(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gapSummary(reconstructed)
+	if got.DataGaps != want.DataGaps || got.LowConfidence != want.LowConfidence || got.LongestGap != want.LongestGap {
+		t.Errorf("pruned gaps = %d, %d, %s, want %d, %d, %s", got.DataGaps, got.LowConfidence, got.LongestGap,
+			want.DataGaps, want.LowConfidence, want.LongestGap)
+	}
+	// Pruning again merges the already merged flags
+	again, err := retainedRows(reconstructed, []bool{true, false, true}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconstructed, err = rethinkdb.ReconstructSnapshots(again); err != nil {
+		t.Fatal(err)
+	}
+	if got := gapSummary(reconstructed); got.DataGaps != want.DataGaps || got.LowConfidence != want.LowConfidence {
+		t.Errorf("pruned twice gaps = %d, %d, want %d, %d", got.DataGaps, got.LowConfidence, want.DataGaps, want.LowConfidence)
+	}
+}
+
+func TestArchive_CreatedOnWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := newArchive(filepath.Join(dir, "archive"), time.Date(2019, time.September, 8, 0, 0, 0, 0, time.UTC))
+	if err := out.Write(nil); err != nil || out.Created() {
+		t.Errorf("Write(nil) = %v, created %v", err, out.Created())
+	}
+	if err := out.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if _, err := os.Stat(out.path); !os.IsNotExist(err) {
+		t.Errorf("archive exists without any pruned rows")
+	}
+	out = newArchive(filepath.Join(dir, "archive"), time.Date(2019, time.September, 8, 0, 0, 0, 0, time.UTC))
+	if err := out.Write([]rethinkdb.RethinkTornUser{snapshotAt(time.Now(), "1")}); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := out.Close(); err != nil || out.Size() == 0 {
+		t.Errorf("Close() = %v, size %d", err, out.Size())
+	}
+}
@@ -4,25 +4,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
-	"log"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 	"torn/model"
 	"torn/rethinkdb"
+	"torn/thealth"
+	"torn/tlog"
+	"torn/tmetrics"
 	"torn/treporter"
+	"torn/twebhook"
 )
 
 type Args struct {
 	BootstrapServer string
 	RethinkdbServer string
+	Health          *thealth.Checker
+	KeyframeInterval int // snapshots per keyframe; 0 or 1 stores every snapshot in full
+	Webhooks bool // sends events from each new diff to webhook subscribers
+	WebhookRetries int
 }
 
 const GroupIdV1 = "rethinkdb-tconsumer-v4"
 const GroupIdV3 = "rethinkdb-tconsumer-v5"
+const GroupIdAttacks = "rethinkdb-attack-tconsumer-v1"
 
-func SetUpConsumer(bootstrapServer string, groupId string) (*kafka.Consumer, func()) {
+// How long shutdown waits for queued webhook deliveries and their retries
+const WebhookShutdownTimeout = time.Second * 30
+
+func SetUpConsumer(bootstrapServer string, groupId string, topic string) (*kafka.Consumer, func()) {
 	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
 		"bootstrap.servers": bootstrapServer,
 		"group.id" : groupId,
@@ -30,18 +42,18 @@ func SetUpConsumer(bootstrapServer string, groupId string) (*kafka.Consumer, fun
 		"enable.auto.commit": "false",
 	})
 	if err != nil {
-		log.Printf("Failed to create tconsumer: %s\n", err)
+		tlog.WithError(err).Errorf("Failed to create tconsumer")
 		os.Exit(1)
 	}
-	err = consumer.SubscribeTopics([]string{"TornEnergy"}, nil)
+	err = consumer.SubscribeTopics([]string{topic}, nil)
 	if err != nil {
-		log.Printf("Unable to subscribe to topic: %s\n", err)
+		tlog.WithError(err).WithField("topic", topic).Errorf("Unable to subscribe to topic")
 		os.Exit(1)
 	}
 	return consumer, func() {
 		err := consumer.Close()
 		if err != nil {
-			log.Printf("Unable to close tconsumer: %s\n", err)
+			tlog.WithError(err).Errorf("Unable to close tconsumer")
 		}
 	}
 }
@@ -60,39 +72,86 @@ func ToRethinkTornUser(msg *kafka.Message) (*rethinkdb.RethinkTornUser, error) {
 	}, nil
 }
 
-func RethinkdbStoringConsumer(consumer *kafka.Consumer, userDao rethinkdb.UserDao) {
+func RecordLag(consumer *kafka.Consumer, msg *kafka.Message) {
+	tp := msg.TopicPartition
+	if tp.Topic == nil {
+		return
+	}
+	// Cached watermarks, so this doesn't hit the broker per message
+	_, high, err := consumer.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+	if err != nil {
+		return
+	}
+	tmetrics.ConsumerLag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(high - int64(tp.Offset) - 1))
+}
+
+func RethinkdbStoringConsumer(consumer *kafka.Consumer, userDao rethinkdb.UserDao, writer *rethinkdb.SnapshotWriter, webhooks *twebhook.Dispatcher) {
 	var kerrs uint64
+	// Last stored snapshot and happy jump progress per user, for events from the next diff.
+	// Skipped replays don't update them, so events aren't sent twice
+	prevs := make(map[uint]rethinkdb.RethinkTornUser)
+	detectors := make(map[uint]*model.HappyJumpDetector)
 	go func() {
 		for {
 			msg, err := consumer.ReadMessage(time.Second * 5)
 			// TODO: Retry errors
 			if err != nil {
 				atomic.AddUint64(&kerrs, 1)
-				log.Printf("Consumer error: %v (%v)\n", err, msg)
+				tlog.WithError(err).Warnf("Consumer error")
 				continue
 			}
+			RecordLag(consumer, msg)
 			msg.TopicPartition.Offset += 70000 // Welp, Kafka topic got blown away on instance resize
 			offset := msg.TopicPartition.Offset
+			logger := tlog.WithField("offset", int64(offset))
 			stored, err := userDao.Exists(int64(offset))
 			if err != nil {
-				log.Printf("ERR: Unable to determine if User already exists: offset=%d, err=%s\n", offset, err)
+				logger.WithError(err).Errorf("Unable to determine if User already exists")
 				continue
 			}
 			if stored {
-				log.Printf("User already stored, skipping: offset=%d\n", offset)
+				logger.Debugf("User already stored, skipping")
 				continue
 			}
 			dbUser, err := ToRethinkTornUser(msg)
 			if err != nil {
-				log.Printf("ERR: Unable to convert Kafka message to Rethink model: offset=%d, err=%s\n", offset, err)
+				logger.WithError(err).Errorf("Unable to convert Kafka message to Rethink model")
 				continue
 			}
-			err = userDao.Insert(*dbUser)
+			logger = logger.WithField("user_id", dbUser.Document.UserId)
+			row, err := writer.Compact(*dbUser)
+			if err != nil {
+				logger.WithError(err).Warnf("Unable to compute delta, storing keyframe")
+				writer.Reset(dbUser.Document.UserId)
+				row = *dbUser
+			}
+			err = userDao.Insert(row)
 			if err != nil {
-				log.Printf("ERR: Unable to insert User into db: user=%+v\n", dbUser)
+				// The next snapshot can't be a delta against one that wasn't stored
+				writer.Reset(dbUser.Document.UserId)
+				tmetrics.ConsumerInsertFailures.WithLabelValues("User").Inc()
+				logger.WithError(err).Errorf("Unable to insert User into db")
+				logger.Debugf("Unable to insert User into db: user=%+v", dbUser)
 				continue
 			}
-			log.Printf("Wrote User to db: user=%+v\n", dbUser)
+			logger.Infof("Wrote User to db")
+			logger.Debugf("Wrote User to db: user=%+v", dbUser)
+			userId := dbUser.Document.UserId
+			if prev, found := prevs[userId]; found {
+				udiff := prev.Document.DiffAt(dbUser.Document, prev.Timestamp, dbUser.Timestamp)
+				if detectors[userId] == nil {
+					detectors[userId] = &model.HappyJumpDetector{}
+				}
+				events := append(udiff.GetEvents(), udiff.GetItemEvents()...)
+				if jump := detectors[userId].Next(udiff, prev.Timestamp, dbUser.Timestamp); jump != nil {
+					logger.WithField("event", model.EventHappyJump).Infof("%s", jump.Event())
+					events = append(events, jump.Event())
+				}
+				if webhooks != nil {
+					webhooks.Publish(userId, dbUser.Document.Name, events, udiff.CalculateEnergyTrained(), prev.Timestamp, dbUser.Timestamp)
+				}
+			}
+			prevs[userId] = *dbUser
 			time.Sleep(time.Millisecond * 50)
 		}
 	}()
@@ -103,18 +162,73 @@ func RunConsumer(args Args, done chan bool) {
 }
 
 func RunConsumerV1(args Args, done chan bool) {
-	consumer, closer := SetUpConsumer(args.BootstrapServer, GroupIdV1)
+	consumer, closer := SetUpConsumer(args.BootstrapServer, GroupIdV1, "TornEnergy")
 	defer closer()
+	attackConsumer, attackCloser := SetUpConsumer(args.BootstrapServer, GroupIdAttacks, "TornAttacks")
+	defer attackCloser()
 	session := rethinkdb.SetUpDb(args.RethinkdbServer)
 	userDao := rethinkdb.UserDao{Session: session}
-	RethinkdbStoringConsumer(consumer, userDao)
+	attackDao := rethinkdb.AttackDao{Session: session}
+	args.Health.AddLiveness("rethinkdb", func() error {
+		return rethinkdb.CheckSession(session)
+	})
+	args.Health.AddLiveness("kafka", func() error {
+		for _, c := range []*kafka.Consumer{consumer, attackConsumer} {
+			if _, err := c.GetMetadata(nil, false, 2000); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var webhooks *twebhook.Dispatcher
+	if args.Webhooks {
+		webhooks = twebhook.NewDispatcher(rethinkdb.WebhookDao{Session: session}, args.WebhookRetries)
+		webhooks.Start(4)
+	}
+	RethinkdbStoringConsumer(consumer, userDao, rethinkdb.NewSnapshotWriter(args.KeyframeInterval), webhooks)
+	AttackStoringConsumer(attackConsumer, attackDao)
 	<-done
-	partitions, err := consumer.Commit()
-	if err != nil {
-		log.Printf("Unable to commit to Kafka: %s\n", err)
-	} else {
-		log.Printf("Committing to Kafka: %+v\n", partitions)
+	if webhooks != nil {
+		if !webhooks.Close(WebhookShutdownTimeout) {
+			tlog.Warnf("Gave up waiting for webhook deliveries after %s", WebhookShutdownTimeout)
+		}
 	}
+	for _, c := range []*kafka.Consumer{consumer, attackConsumer} {
+		partitions, err := c.Commit()
+		if err != nil {
+			tlog.WithError(err).Errorf("Unable to commit to Kafka")
+		} else {
+			tlog.Infof("Committing to Kafka: %+v", partitions)
+		}
+	}
+}
+
+func AttackStoringConsumer(consumer *kafka.Consumer, attackDao rethinkdb.AttackDao) {
+	go func() {
+		for {
+			msg, err := consumer.ReadMessage(time.Second * 5)
+			if err != nil {
+				tlog.WithError(err).Warnf("Consumer error")
+				continue
+			}
+			RecordLag(consumer, msg)
+			logger := tlog.WithField("offset", int64(msg.TopicPartition.Offset))
+			var attack model.Attack
+			err = json.Unmarshal(msg.Value, &attack)
+			if err != nil {
+				logger.WithError(err).Errorf("Unable to convert Kafka message to Attack")
+				continue
+			}
+			// Upsert, so replaying the topic is harmless
+			err = attackDao.Upsert([]model.Attack{attack})
+			if err != nil {
+				tmetrics.ConsumerInsertFailures.WithLabelValues("Attack").Inc()
+				logger.WithError(err).WithField("attack_id", attack.Id).Errorf("Unable to insert Attack into db")
+				continue
+			}
+			logger.WithField("attack_id", attack.Id).Infof("Wrote Attack to db")
+		}
+	}()
 }
 
 type UserPair struct {
@@ -134,7 +248,7 @@ type UserEvent struct {
 
 // WIP
 func RunConsumerV3(args Args, done chan bool) {
-	consumer, closer := SetUpConsumer(args.BootstrapServer, GroupIdV3)
+	consumer, closer := SetUpConsumer(args.BootstrapServer, GroupIdV3, "TornEnergy")
 	defer closer()
 	//session := rethinkdb.SetUpDb(args.RethinkdbServer)
 	//userDao := rethinkdb.UserDao{Session: session}
@@ -147,7 +261,7 @@ func RunConsumerV3(args Args, done chan bool) {
 			//log.Println("Reading message")
 			msg, err := consumer.ReadMessage(time.Second * 5)
 			if err != nil {
-				log.Printf("Unable to read message: %v", err)
+				tlog.WithError(err).Warnf("Unable to read message")
 				continue
 			}
 			messages <- msg
@@ -164,7 +278,7 @@ func RunConsumerV3(args Args, done chan bool) {
 			msg := <-messages
 			dbUser, err := ToRethinkTornUser(msg)
 			if err != nil {
-				log.Printf("ERR: Unable to convert Kafka message to Rethink model: offset=%d, err=%s\n", msg.TopicPartition.Offset, err)
+				tlog.WithError(err).WithField("offset", int64(msg.TopicPartition.Offset)).Errorf("Unable to convert Kafka message to Rethink model")
 				continue
 			}
 			// TODO: Remove filtering
@@ -172,7 +286,7 @@ func RunConsumerV3(args Args, done chan bool) {
 				continue
 			}
 			s, _ := json.Marshal(dbUser)
-			log.Printf("Event: %s\n", s)
+			tlog.Debugf("Event: %s", s)
 			users <- dbUser
 		}
 	}()
@@ -203,11 +317,11 @@ func RunConsumerV3(args Args, done chan bool) {
 				udiff := pair.Prev.Document.Diff(pair.Curr.Document)
 				diff, _ := json.Marshal(udiff)
 				trained := udiff.CalculateEnergyTrained()
-				events := udiff.GetEvents()
+				events := append(udiff.GetEvents(), udiff.GetItemEvents()...)
 				if trained > 0 || len(events) > 0 {
-					log.Printf("Diff (id=%d): %s (t=%d) (e=%d)\n", pair.Prev.Document.UserId, diff, trained, len(events))
+					tlog.WithField("user_id", pair.Prev.Document.UserId).Debugf("Diff: %s (t=%d) (e=%d)", diff, trained, len(events))
 					for _, e := range events {
-						log.Printf("  %s (b=%s, a=%s)\n", e, pair.Prev.Timestamp, pair.Curr.Timestamp)
+						tlog.Infof("  %s (b=%s, a=%s)", e, pair.Prev.Timestamp, pair.Curr.Timestamp)
 					}
 				}
 				mux.Lock()
@@ -216,12 +330,12 @@ func RunConsumerV3(args Args, done chan bool) {
 			}
 
 			if pc % 100 == 0 {
-				log.Printf("Processed: %d\n", pc)
+				tlog.Infof("Processed: %d", pc)
 			}
 		}
 	}()
 	<-done
-	log.Printf("Processed: %d\n", pc)
+	tlog.Infof("Processed: %d", pc)
 	sorted := treporter.SortMapByValue(usertrained)
 	for _, v := range sorted {
 		fmt.Printf("%d: %d energy trained\n", v.Key, v.Value)
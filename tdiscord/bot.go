@@ -0,0 +1,394 @@
+package tdiscord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+	"torn/thttp"
+	"torn/tlog"
+	"torn/treporter"
+)
+
+const (
+	CommandLeaderboard = "leaderboard"
+	CommandMe          = "me"
+	CommandEvents      = "events"
+	CommandRegister    = "register"
+)
+
+// Rows shown by /leaderboard and /events
+const (
+	LeaderboardRows = 15
+	EventRows       = 20
+)
+
+const maxInteractionSize = 64 * 1024
+
+// Signed requests older (or further ahead) than this are rejected as replays
+const MaxTimestampSkew = time.Minute * 5
+
+type Args struct {
+	RethinkdbServer  string
+	Port             string
+	FactionId        uint
+	AnomalyPolicy    string
+	PublicKey        string // hex encoded, from the Discord developer portal
+	ApplicationId    string
+	BotToken         string
+	ApiBase          string
+	RegisterCommands bool
+}
+
+// What the commands read and write, backed by the reporter and RethinkDB outside of tests
+type Backend interface {
+	Leaderboard(week string) ([]model.UserSummary, error)
+	Events(userId uint, week string) ([]model.TimedEvent, error)
+	Register(discordUserId string, apiKey string) (*rethinkdb.RegisteredKey, error)
+	Registered(discordUserId string) (*rethinkdb.RegisteredKey, error) // nil when not registered
+}
+
+type Bot struct {
+	PublicKey ed25519.PublicKey
+	Client    *Client
+	Backend   Backend
+}
+
+// Discord signs the timestamp followed by the body
+func Verify(key ed25519.PublicKey, signature string, timestamp string, body []byte) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(key, append([]byte(timestamp), body...), sig)
+}
+
+// Whether the unix seconds timestamp Discord signed is within MaxTimestampSkew of now
+func Fresh(timestamp string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	return skew <= MaxTimestampSkew && skew >= -MaxTimestampSkew
+}
+
+// Answers pings and defers commands, editing the response once the command has run
+func (b Bot) InteractionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		thttp.WriteJsonResponse(http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"}, w)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxInteractionSize))
+	if err != nil {
+		thttp.WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "unable to read body"}, w)
+		return
+	}
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if !Verify(b.PublicKey, r.Header.Get("X-Signature-Ed25519"), timestamp, body) || !Fresh(timestamp, time.Now()) {
+		thttp.WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "invalid request signature"}, w)
+		return
+	}
+	var interaction Interaction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		thttp.WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid interaction"}, w)
+		return
+	}
+	switch interaction.Type {
+	case InteractionPing:
+		thttp.WriteJsonResponse(http.StatusOK, InteractionResponse{Type: ResponsePong}, w)
+	case InteractionCommand:
+		data := &ResponseData{}
+		if interaction.Data.Name == CommandRegister || interaction.Data.Name == CommandMe {
+			data.Flags = FlagEphemeral
+		}
+		thttp.WriteJsonResponse(http.StatusOK, InteractionResponse{Type: ResponseDeferredMessage, Data: data}, w)
+		go b.respond(interaction)
+	default:
+		thttp.WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "unsupported interaction type"}, w)
+	}
+}
+
+func (b Bot) respond(interaction Interaction) {
+	content := b.Execute(interaction)
+	if err := b.Client.EditOriginal(interaction.Token, content); err != nil {
+		tlog.WithError(err).WithField("command", interaction.Data.Name).Errorf("Unable to send Discord response")
+	}
+}
+
+// Runs the command, returning the message shown to the caller
+func (b Bot) Execute(interaction Interaction) string {
+	var content string
+	var err error
+	switch interaction.Data.Name {
+	case CommandLeaderboard:
+		content, err = b.leaderboard(interaction)
+	case CommandMe:
+		content, err = b.me(interaction)
+	case CommandEvents:
+		content, err = b.events(interaction)
+	case CommandRegister:
+		content, err = b.register(interaction)
+	default:
+		err = errors.New("unknown command: " + interaction.Data.Name)
+	}
+	if err != nil {
+		return "Sorry, " + err.Error()
+	}
+	return content
+}
+
+func competition(interaction Interaction) (*model.Competition, error) {
+	week := interaction.Option("week")
+	if week == "" {
+		week = model.ActiveCompetitions.Default(time.Now())
+	}
+	c, err := model.ActiveCompetitions.Get(week)
+	if err != nil {
+		return nil, fmt.Errorf("unknown week %q, expected one of: %s", week, strings.Join(model.ActiveCompetitions.Ids(), ", "))
+	}
+	return c, nil
+}
+
+// Score when the competition isn't raw energy, like the web leaderboard
+func defaultBoard(c *model.Competition) string {
+	if !c.Scoring.IsRaw() {
+		return treporter.BoardScore
+	}
+	return treporter.BoardEnergy
+}
+
+func (b Bot) leaderboard(interaction Interaction) (string, error) {
+	c, err := competition(interaction)
+	if err != nil {
+		return "", err
+	}
+	board := interaction.Option("board")
+	if board == "" {
+		board = defaultBoard(c)
+	} else if !treporter.IsBoard(board) {
+		return "", errors.New("invalid board, expected one of: energy, nerve, crimes, score")
+	}
+	summaries, err := b.Backend.Leaderboard(c.Id)
+	if err != nil {
+		tlog.WithError(err).WithField("week", c.Id).Errorf("Unable to calculate leaderboard for Discord")
+		return "", errors.New("the leaderboard isn't available right now")
+	}
+	ranked := treporter.RankForBoard(summaries, board)
+	lines := []string{fmt.Sprintf("**%s** leaderboard (%s)", c.Name, board), "```"}
+	for i, summary := range ranked {
+		if i == LeaderboardRows {
+			break
+		}
+		lines = append(lines, treporter.FormatBoardSummary(board, i, summary))
+	}
+	if len(ranked) == 0 {
+		lines = append(lines, "Nobody has trained yet")
+	}
+	lines = append(lines, "```")
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b Bot) registeredUser(interaction Interaction) (*rethinkdb.RegisteredKey, error) {
+	key, err := b.Backend.Registered(interaction.Caller().Id)
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to look up registered key")
+		return nil, errors.New("unable to look up your registration right now")
+	}
+	if key == nil {
+		return nil, errors.New("you haven't registered a key yet, use /register")
+	}
+	return key, nil
+}
+
+func (b Bot) me(interaction Interaction) (string, error) {
+	c, err := competition(interaction)
+	if err != nil {
+		return "", err
+	}
+	key, err := b.registeredUser(interaction)
+	if err != nil {
+		return "", err
+	}
+	summaries, err := b.Backend.Leaderboard(c.Id)
+	if err != nil {
+		tlog.WithError(err).WithField("week", c.Id).Errorf("Unable to calculate leaderboard for Discord")
+		return "", errors.New("the leaderboard isn't available right now")
+	}
+	board := defaultBoard(c)
+	for i, summary := range treporter.RankForBoard(summaries, board) {
+		if summary.User == key.UserId {
+			return fmt.Sprintf("**%s**\n```\n%s\n```", c.Name, treporter.FormatBoardSummary(board, i, summary)), nil
+		}
+	}
+	return fmt.Sprintf("Nothing recorded for %s [%d] in %s yet", key.Name, key.UserId, c.Name), nil
+}
+
+func (b Bot) events(interaction Interaction) (string, error) {
+	c, err := competition(interaction)
+	if err != nil {
+		return "", err
+	}
+	var userId uint
+	if user := interaction.Option("user"); user != "" {
+		id, err := strconv.ParseUint(user, 10, 32)
+		if err != nil {
+			return "", errors.New("invalid user, expected a Torn user ID")
+		}
+		userId = uint(id)
+	} else {
+		key, err := b.registeredUser(interaction)
+		if err != nil {
+			return "", err
+		}
+		userId = key.UserId
+	}
+	events, err := b.Backend.Events(userId, c.Id)
+	if err != nil {
+		tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to calculate events for Discord")
+		return "", errors.New("events aren't available right now")
+	}
+	if len(events) == 0 {
+		return fmt.Sprintf("No events for [%d] in %s", userId, c.Name), nil
+	}
+	if len(events) > EventRows {
+		events = events[len(events)-EventRows:]
+	}
+	lines := []string{fmt.Sprintf("**%s** events for [%d]", c.Name, userId), "```"}
+	for _, e := range events {
+		lines = append(lines, fmt.Sprintf("%s %s", e.To.UTC().Format("Jan 2 15:04"), e.Text))
+	}
+	lines = append(lines, "```")
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b Bot) register(interaction Interaction) (string, error) {
+	apiKey := interaction.Option("key")
+	if apiKey == "" {
+		return "", errors.New("a key is required")
+	}
+	tlog.RegisterSecret(apiKey)
+	key, err := b.Backend.Register(interaction.Caller().Id, apiKey)
+	if err != nil {
+		return "", errors.New("unable to register key: " + err.Error())
+	}
+	return fmt.Sprintf("Registered %s [%d]. Tracking starts when the producer next restarts.", key.Name, key.UserId), nil
+}
+
+type ReporterBackend struct {
+	Reporter   *treporter.Reporter
+	KeyDao     *rethinkdb.KeyDao
+	TornClient *thttp.TornClient
+	Cache      *cache.Cache
+}
+
+func (rb ReporterBackend) Leaderboard(week string) ([]model.UserSummary, error) {
+	if cached, found := rb.Cache.Get(week); found {
+		return cached.([]model.UserSummary), nil
+	}
+	c, err := model.ActiveCompetitions.Get(week)
+	if err != nil {
+		return nil, err
+	}
+	summaries, _, err := rb.Reporter.CalculateEnergyTrained(c.Begin, c.End)
+	if err != nil {
+		return nil, err
+	}
+	treporter.ApplyScoring(summaries, c.Scoring)
+	rb.Cache.Set(week, summaries, time.Minute)
+	return summaries, nil
+}
+
+func (rb ReporterBackend) Events(userId uint, week string) ([]model.TimedEvent, error) {
+	c, err := model.ActiveCompetitions.Get(week)
+	if err != nil {
+		return nil, err
+	}
+	return rb.Reporter.CalculateEvents(userId, c.Begin, c.End)
+}
+
+// Checks the key against Torn before storing it
+func (rb ReporterBackend) Register(discordUserId string, apiKey string) (*rethinkdb.RegisteredKey, error) {
+	user, tornError, err := rb.TornClient.GetUser(apiKey)
+	if err != nil {
+		return nil, errors.New("unable to reach Torn")
+	} else if tornError != nil {
+		return nil, tornError.GetError()
+	}
+	key := rethinkdb.RegisteredKey{
+		UserId:        user.UserId,
+		Name:          user.Name,
+		ApiKey:        apiKey,
+		DiscordUserId: discordUserId,
+		Registered:    time.Now(),
+	}
+	if err := rb.KeyDao.Upsert(key); err != nil {
+		tlog.WithError(err).WithField("user_id", user.UserId).Errorf("Unable to store registered key")
+		return nil, errors.New("unable to store key")
+	}
+	tlog.WithField("user_id", user.UserId).Infof("Registered API key through Discord")
+	return &key, nil
+}
+
+func (rb ReporterBackend) Registered(discordUserId string) (*rethinkdb.RegisteredKey, error) {
+	return rb.KeyDao.GetByDiscordUser(discordUserId)
+}
+
+func RunBot(args Args, done chan bool) {
+	publicKey, err := hex.DecodeString(args.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		tlog.Fatalf("Invalid Discord public key")
+	}
+	tlog.RegisterSecret(args.BotToken)
+	client := NewClient(args.ApiBase, args.ApplicationId, args.BotToken)
+	if args.RegisterCommands {
+		if err := client.RegisterCommands(Commands); err != nil {
+			tlog.WithError(err).Errorf("Unable to register Discord commands")
+		} else {
+			tlog.WithField("commands", len(Commands)).Infof("Registered Discord commands")
+		}
+	}
+	session := rethinkdb.SetUpDb(args.RethinkdbServer)
+	defer session.Close()
+	userDao := rethinkdb.UserDao{Session: session}
+	attackDao := rethinkdb.AttackDao{Session: session}
+	keyDao := rethinkdb.KeyDao{Session: session}
+	bot := Bot{
+		PublicKey: ed25519.PublicKey(publicKey),
+		Client:    client,
+		Backend: ReporterBackend{
+			Reporter: &treporter.Reporter{
+				UserDao:       &userDao,
+				AttackDao:     &attackDao,
+				FactionId:     args.FactionId,
+				AnomalyPolicy: args.AnomalyPolicy,
+			},
+			KeyDao:     &keyDao,
+			TornClient: thttp.NewTornClient(),
+			Cache:      cache.New(time.Minute, time.Minute),
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/interactions", bot.InteractionHandler)
+	srv := &http.Server{Addr: args.Port, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			tlog.Fatalf("ListenAndServe(): %s", err)
+		}
+	}()
+	<-done
+	if err := srv.Shutdown(context.TODO()); err != nil {
+		tlog.WithError(err).Errorf("Unable to shut down Discord interactions server")
+	}
+}
@@ -0,0 +1,218 @@
+package tdiscord
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+)
+
+type fakeBackend struct {
+	keys map[string]*rethinkdb.RegisteredKey
+}
+
+func (f *fakeBackend) Leaderboard(week string) ([]model.UserSummary, error) {
+	if week != "2" {
+		return nil, nil
+	}
+	return []model.UserSummary{
+		{User: 1, Name: "Alice", Energy: 1500},
+		{User: 2, Name: "Bob", Energy: 3000},
+	}, nil
+}
+
+func (f *fakeBackend) Events(userId uint, week string) ([]model.TimedEvent, error) {
+	at := time.Date(2019, time.September, 1, 12, 0, 0, 0, time.UTC)
+	return []model.TimedEvent{{Event: model.Event{Type: model.EventOverdose, Text: "overdosed, RIP"}, From: at, To: at.Add(time.Minute)}}, nil
+}
+
+func (f *fakeBackend) Register(discordUserId string, apiKey string) (*rethinkdb.RegisteredKey, error) {
+	if apiKey != "good" {
+		return nil, errors.New("IncorrectKey")
+	}
+	key := &rethinkdb.RegisteredKey{UserId: 1, Name: "Alice", ApiKey: apiKey, DiscordUserId: discordUserId}
+	f.keys[discordUserId] = key
+	return key, nil
+}
+
+func (f *fakeBackend) Registered(discordUserId string) (*rethinkdb.RegisteredKey, error) {
+	return f.keys[discordUserId], nil
+}
+
+// Records what the bot sends to the Discord HTTP API
+type fakeDiscord struct {
+	edits    chan string
+	commands []Command
+	auth     string
+}
+
+func newFakeDiscord(t *testing.T) (*fakeDiscord, *httptest.Server) {
+	fake := &fakeDiscord{edits: make(chan string, 10)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/applications/app/commands", func(w http.ResponseWriter, r *http.Request) {
+		fake.auth = r.Header.Get("Authorization")
+		if r.Method != http.MethodPut {
+			t.Errorf("commands method = %s, want PUT", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&fake.commands); err != nil {
+			t.Errorf("Unable to decode commands: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/webhooks/app/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/webhooks/app/tok/messages/@original" {
+			t.Errorf("unexpected edit: %s %s", r.Method, r.URL.Path)
+		}
+		var data ResponseData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			t.Errorf("Unable to decode edit: %v", err)
+		}
+		fake.edits <- data.Content
+		w.WriteHeader(http.StatusOK)
+	})
+	return fake, httptest.NewServer(mux)
+}
+
+func signedRequest(t *testing.T, key ed25519.PrivateKey, interaction Interaction) *http.Request {
+	return signedRequestAt(t, key, interaction, time.Now())
+}
+
+func signedRequestAt(t *testing.T, key ed25519.PrivateKey, interaction Interaction, at time.Time) *http.Request {
+	body, err := json.Marshal(interaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/interactions", bytes.NewReader(body))
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, append([]byte(timestamp), body...))))
+	return req
+}
+
+func command(name string, options ...Option) Interaction {
+	return Interaction{Type: InteractionCommand, ApplicationId: "app", Token: "tok",
+		Data: InteractionData{Name: name, Options: options}, Member: &Member{User: User{Id: "d1", Username: "alice"}}}
+}
+
+func TestClient_RegisterCommands(t *testing.T) {
+	fake, server := newFakeDiscord(t)
+	defer server.Close()
+	if err := NewClient(server.URL, "app", "secret").RegisterCommands(Commands); err != nil {
+		t.Fatalf("RegisterCommands() error = %v", err)
+	}
+	if fake.auth != "Bot secret" {
+		t.Errorf("Authorization = %q, want %q", fake.auth, "Bot secret")
+	}
+	if len(fake.commands) != len(Commands) || fake.commands[0].Name != CommandLeaderboard {
+		t.Errorf("commands = %+v, want %+v", fake.commands, Commands)
+	}
+}
+
+func TestFresh(t *testing.T) {
+	now := time.Date(2019, time.September, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		timestamp string
+		want      bool
+	}{
+		{strconv.FormatInt(now.Unix(), 10), true},
+		{strconv.FormatInt(now.Add(-MaxTimestampSkew).Unix(), 10), true},
+		{strconv.FormatInt(now.Add(-MaxTimestampSkew-time.Second).Unix(), 10), false},
+		{strconv.FormatInt(now.Add(MaxTimestampSkew+time.Second).Unix(), 10), false},
+		{"", false},
+		{"yesterday", false},
+	}
+	for _, tt := range tests {
+		if got := Fresh(tt.timestamp, now); got != tt.want {
+			t.Errorf("Fresh(%q) = %v, want %v", tt.timestamp, got, tt.want)
+		}
+	}
+}
+
+func TestBot_InteractionHandler(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake, server := newFakeDiscord(t)
+	defer server.Close()
+	bot := Bot{PublicKey: public, Client: NewClient(server.URL, "app", "secret"), Backend: &fakeBackend{keys: map[string]*rethinkdb.RegisteredKey{}}}
+
+	// Unsigned and pings
+	req := signedRequest(t, private, Interaction{Type: InteractionPing})
+	req.Header.Set("X-Signature-Timestamp", strconv.FormatInt(time.Now().Unix()+1, 10))
+	w := httptest.NewRecorder()
+	bot.InteractionHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered request status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	// Correctly signed but replayed long after Discord sent it
+	w = httptest.NewRecorder()
+	bot.InteractionHandler(w, signedRequestAt(t, private, Interaction{Type: InteractionPing}, time.Now().Add(-time.Hour)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("stale request status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w = httptest.NewRecorder()
+	bot.InteractionHandler(w, signedRequest(t, private, Interaction{Type: InteractionPing}))
+	var response InteractionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Type != ResponsePong {
+		t.Errorf("ping response = %s, want pong", w.Body.String())
+	}
+
+	tests := []struct {
+		name        string
+		interaction Interaction
+		ephemeral   bool
+		want        []string
+	}{
+		{"leaderboard", command(CommandLeaderboard, Option{Name: "week", Value: "2"}), false,
+			[]string{"**Week 2** leaderboard (energy)", "#1 [2 (Bob)] 3000", "#2 [1 (Alice)] 1500"}},
+		{"leaderboard invalid week", command(CommandLeaderboard, Option{Name: "week", Value: "9"}), false,
+			[]string{"Sorry, unknown week \"9\""}},
+		{"me before registering", command(CommandMe, Option{Name: "week", Value: "2"}), true,
+			[]string{"Sorry, you haven't registered a key yet"}},
+		{"register bad key", command(CommandRegister, Option{Name: "key", Value: "bad"}), true,
+			[]string{"Sorry, unable to register key: IncorrectKey"}},
+		{"register", command(CommandRegister, Option{Name: "key", Value: "good"}), true,
+			[]string{"Registered Alice [1]"}},
+		{"me", command(CommandMe, Option{Name: "week", Value: "2"}), true,
+			[]string{"**Week 2**", "#2 [1 (Alice)] 1500"}},
+		{"events", command(CommandEvents, Option{Name: "user", Value: "1"}, Option{Name: "week", Value: "2"}), false,
+			[]string{"Sep 1 12:01 overdosed, RIP"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			bot.InteractionHandler(w, signedRequest(t, private, tt.interaction))
+			var response InteractionResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Unable to decode response %s: %v", w.Body.String(), err)
+			}
+			if response.Type != ResponseDeferredMessage {
+				t.Errorf("response type = %d, want %d", response.Type, ResponseDeferredMessage)
+			}
+			if ephemeral := response.Data != nil && response.Data.Flags == FlagEphemeral; ephemeral != tt.ephemeral {
+				t.Errorf("ephemeral = %v, want %v", ephemeral, tt.ephemeral)
+			}
+			select {
+			case content := <-fake.edits:
+				for _, want := range tt.want {
+					if !strings.Contains(content, want) {
+						t.Errorf("content = %q, want it to contain %q", content, want)
+					}
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("no response edited")
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package tdiscord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultApiBase = "https://discord.com/api/v8"
+
+// Discord limits message content to this many characters
+const MaxContentLength = 2000
+
+// Interaction, response and option types from the Discord interactions API
+const (
+	InteractionPing    = 1
+	InteractionCommand = 2
+
+	ResponsePong            = 1
+	ResponseDeferredMessage = 5 // "thinking..." until the original response is edited
+
+	FlagEphemeral = 64 // only the caller sees the response
+
+	OptionString = 3
+)
+
+type User struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type Member struct {
+	User User `json:"user"`
+}
+
+// Every option the bot declares is a string
+type Option struct {
+	Name  string `json:"name"`
+	Type  int    `json:"type"`
+	Value string `json:"value"`
+}
+
+type InteractionData struct {
+	Name    string   `json:"name"`
+	Options []Option `json:"options,omitempty"`
+}
+
+type Interaction struct {
+	Id            string          `json:"id"`
+	ApplicationId string          `json:"application_id"`
+	Type          int             `json:"type"`
+	Token         string          `json:"token"`
+	Data          InteractionData `json:"data"`
+	Member        *Member         `json:"member,omitempty"` // set in guilds
+	User          *User           `json:"user,omitempty"`   // set in DMs
+}
+
+func (i Interaction) Option(name string) string {
+	for _, o := range i.Data.Options {
+		if o.Name == name {
+			return strings.TrimSpace(o.Value)
+		}
+	}
+	return ""
+}
+
+func (i Interaction) Caller() User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return User{}
+}
+
+type ResponseData struct {
+	Content string `json:"content,omitempty"`
+	Flags   int    `json:"flags,omitempty"`
+}
+
+type InteractionResponse struct {
+	Type int           `json:"type"`
+	Data *ResponseData `json:"data,omitempty"`
+}
+
+type CommandOption struct {
+	Type        int    `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type Command struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Options     []CommandOption `json:"options,omitempty"`
+}
+
+var Commands = []Command{
+	{Name: CommandLeaderboard, Description: "Shows the leaderboard", Options: []CommandOption{
+		{Type: OptionString, Name: "week", Description: "Competition, the current one by default"},
+		{Type: OptionString, Name: "board", Description: "energy, nerve, crimes or score"},
+	}},
+	{Name: CommandMe, Description: "Shows your rank and training", Options: []CommandOption{
+		{Type: OptionString, Name: "week", Description: "Competition, the current one by default"},
+	}},
+	{Name: CommandEvents, Description: "Shows recent events", Options: []CommandOption{
+		{Type: OptionString, Name: "user", Description: "Torn user ID, yours by default"},
+		{Type: OptionString, Name: "week", Description: "Competition, the current one by default"},
+	}},
+	{Name: CommandRegister, Description: "Registers your Torn API key for tracking", Options: []CommandOption{
+		{Type: OptionString, Name: "key", Description: "Torn API key", Required: true},
+	}},
+}
+
+// Discord HTTP API client; ApiBase is swapped for a local fake in tests
+type Client struct {
+	ApiBase       string
+	ApplicationId string
+	BotToken      string
+	Client        *http.Client
+}
+
+func NewClient(apiBase string, applicationId string, botToken string) *Client {
+	return &Client{
+		ApiBase:       strings.TrimRight(apiBase, "/"),
+		ApplicationId: applicationId,
+		BotToken:      botToken,
+		Client:        &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (c Client) do(method string, path string, body interface{}, auth bool) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.ApiBase+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth {
+		req.Header.Set("Authorization", "Bot "+c.BotToken)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("discord responded with status %d: %s", resp.StatusCode, message)
+	}
+	return nil
+}
+
+// Replaces the application's global commands
+func (c Client) RegisterCommands(commands []Command) error {
+	return c.do(http.MethodPut, "/applications/"+c.ApplicationId+"/commands", commands, true)
+}
+
+// Fills in a deferred response
+func (c Client) EditOriginal(token string, content string) error {
+	if len(content) > MaxContentLength {
+		content = content[:MaxContentLength-3] + "..."
+	}
+	path := "/webhooks/" + c.ApplicationId + "/" + token + "/messages/@original"
+	return c.do(http.MethodPatch, path, ResponseData{Content: content}, false)
+}
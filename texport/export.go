@@ -0,0 +1,205 @@
+package texport
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+	"torn/tlog"
+	"torn/tmetrics"
+	"torn/treporter"
+)
+
+const (
+	KindSnapshots   = "snapshots"
+	KindDiffs       = "diffs"
+	KindLeaderboard = "leaderboard"
+)
+
+var prototypes = map[string]func() interface{}{
+	KindSnapshots:   func() interface{} { return new(SnapshotRecord) },
+	KindDiffs:       func() interface{} { return new(DiffRecord) },
+	KindLeaderboard: func() interface{} { return new(SummaryRecord) },
+}
+
+func IsKind(kind string) bool {
+	_, ok := prototypes[kind]
+	return ok
+}
+
+type Args struct {
+	RethinkdbServer string
+	AnomalyPolicy   string
+	Kind            string
+	Format          string
+	Board           string
+	Earliest        time.Time
+	Latest          time.Time
+	Output          string // file to write to, stdout when empty or "-"
+}
+
+type Exporter struct {
+	Reporter *treporter.Reporter
+	Scoring  model.Scoring // applied to exported leaderboards
+}
+
+// Streams records of kind between earliest and latest to w; snapshots and diffs are read a
+// user at a time so memory stays bounded by the largest user history
+func (e Exporter) Export(kind string, format string, board string, earliest time.Time, latest time.Time, w io.Writer) (int, error) {
+	if !IsKind(kind) {
+		return 0, errors.New("invalid kind, expected one of: [snapshots, diffs, leaderboard]")
+	}
+	if kind == KindLeaderboard && !treporter.IsBoard(board) {
+		return 0, errors.New("invalid board, expected one of: [energy, nerve, crimes, score]")
+	}
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "export", time.Now())
+	out, err := NewRecordWriter(format, prototypes[kind](), w)
+	if err != nil {
+		return 0, err
+	}
+	var written int
+	switch kind {
+	case KindLeaderboard:
+		written, err = e.exportLeaderboard(out, board, earliest, latest)
+	default:
+		written, err = e.exportHistory(out, kind, earliest, latest)
+	}
+	if err != nil {
+		return written, err
+	}
+	return written, out.Close()
+}
+
+func (e Exporter) exportLeaderboard(out RecordWriter, board string, earliest time.Time, latest time.Time) (int, error) {
+	summaries, _, err := e.Reporter.CalculateEnergyTrained(earliest, latest)
+	if err != nil {
+		return 0, err
+	}
+	treporter.ApplyScoring(summaries, e.Scoring)
+	ranked := treporter.RankForBoard(summaries, board)
+	for rank, summary := range ranked {
+		if err := out.Write(NewSummaryRecord(rank, summary)); err != nil {
+			return rank, err
+		}
+	}
+	return len(ranked), nil
+}
+
+func (e Exporter) exportHistory(out RecordWriter, kind string, earliest time.Time, latest time.Time) (int, error) {
+	userIds, err := e.Reporter.UserDao.GetUserIds()
+	if err != nil {
+		return 0, err
+	}
+	var written int
+	for _, userId := range userIds {
+		userData, err := e.Reporter.UserDao.GetInRange(userId, earliest, latest)
+		if err != nil {
+			tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to get history for User")
+			continue
+		}
+		var n int
+		if kind == KindSnapshots {
+			n, err = writeSnapshots(out, userData)
+		} else {
+			n, err = writeDiffs(out, userData)
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func writeSnapshots(out RecordWriter, userData []rethinkdb.RethinkTornUser) (int, error) {
+	for i, snapshot := range userData {
+		record, err := NewSnapshotRecord(snapshot)
+		if err != nil {
+			return i, err
+		}
+		if err := out.Write(record); err != nil {
+			return i, err
+		}
+	}
+	return len(userData), nil
+}
+
+// Diffs are taken the way the leaderboard takes them, refills reconciled included
+func writeDiffs(out RecordWriter, userData []rethinkdb.RethinkTornUser) (int, error) {
+	var name string
+	for _, snapshot := range userData {
+		if snapshot.Document.Name != "" {
+			name = snapshot.Document.Name
+		}
+	}
+	var refills model.RefillReconciler
+	for i := 0; i < len(userData)-1; i++ {
+		prev, next := userData[i], userData[i+1]
+		diff := prev.DiffTo(next)
+		refills.Reconcile(&diff)
+		record, err := NewDiffRecord(name, diff, prev.Timestamp, next.Timestamp)
+		if err != nil {
+			return i, err
+		}
+		if err := out.Write(record); err != nil {
+			return i, err
+		}
+	}
+	if len(userData) < 2 {
+		return 0, nil
+	}
+	return len(userData) - 1, nil
+}
+
+// Returns an error when the export fails or is interrupted, after removing a partial output file
+func RunExport(args Args, done chan bool) error {
+	session := rethinkdb.SetUpDb(args.RethinkdbServer)
+	defer session.Close()
+	userDao := rethinkdb.UserDao{Session: session}
+	exporter := Exporter{Reporter: &treporter.Reporter{UserDao: &userDao, AnomalyPolicy: args.AnomalyPolicy}}
+	// Ranges matching a competition are scored the way its leaderboard is
+	if c, ok := model.ActiveCompetitions.Spanning(args.Earliest, args.Latest); ok {
+		exporter.Scoring = c.Scoring
+	}
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if args.Output != "" && args.Output != "-" {
+		var err error
+		if file, err = os.Create(args.Output); err != nil {
+			tlog.WithError(err).WithField("path", args.Output).Errorf("Unable to create export file")
+			return err
+		}
+		w = file
+	}
+	finished := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		written, err := exporter.Export(args.Kind, args.Format, args.Board, args.Earliest, args.Latest, w)
+		if err == nil {
+			tlog.WithFields(tlog.Fields{"kind": args.Kind, "format": args.Format, "records": written}).
+				Infof("Export finished in %s", time.Since(start).Round(time.Millisecond))
+		}
+		finished <- err
+	}()
+	var err error
+	select {
+	case err = <-finished:
+	case <-done:
+		err = errors.New("export interrupted")
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		tlog.WithError(err).WithFields(tlog.Fields{"kind": args.Kind, "format": args.Format}).Errorf("Unable to export")
+	}
+	return err
+}
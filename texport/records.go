@@ -0,0 +1,148 @@
+package texport
+
+import (
+	"encoding/json"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+)
+
+// Flat rows shared by every format: json tags name JSON-lines keys and CSV columns, parquet
+// tags describe the Parquet schema. Timestamps are RFC 3339 strings and nested values
+// (documents, diffs, events) are JSON strings
+
+type SnapshotRecord struct {
+	UserId    int64  `json:"user_id" parquet:"name=user_id, type=INT64"`
+	Name      string `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Timestamp string `json:"timestamp" parquet:"name=timestamp, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Offset    int64  `json:"offset" parquet:"name=offset, type=INT64"`
+	Energy    int64  `json:"energy" parquet:"name=energy, type=INT64"`
+	MaxEnergy int64  `json:"max_energy" parquet:"name=max_energy, type=INT64"`
+	Happy     int64  `json:"happy" parquet:"name=happy, type=INT64"`
+	Nerve     int64  `json:"nerve" parquet:"name=nerve, type=INT64"`
+	Strength  string `json:"strength" parquet:"name=strength, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Speed     string `json:"speed" parquet:"name=speed, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Dexterity string `json:"dexterity" parquet:"name=dexterity, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Defense   string `json:"defense" parquet:"name=defense, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Document  string `json:"document" parquet:"name=document, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func NewSnapshotRecord(snapshot rethinkdb.RethinkTornUser) (SnapshotRecord, error) {
+	u := snapshot.Document
+	document, err := json.Marshal(u)
+	if err != nil {
+		return SnapshotRecord{}, err
+	}
+	return SnapshotRecord{
+		UserId:    int64(u.UserId),
+		Name:      u.Name,
+		Timestamp: snapshot.Timestamp.UTC().Format(time.RFC3339),
+		Offset:    snapshot.Offset,
+		Energy:    int64(u.Bars.Energy.Current),
+		MaxEnergy: int64(u.Bars.Energy.Maximum),
+		Happy:     int64(u.Bars.Happy.Current),
+		Nerve:     int64(u.Bars.Nerve.Current),
+		Strength:  u.BattleStats.Strength,
+		Speed:     u.BattleStats.Speed,
+		Dexterity: u.BattleStats.Dexterity,
+		Defense:   u.BattleStats.Defense,
+		Document:  string(document),
+	}, nil
+}
+
+type DiffRecord struct {
+	UserId         int64   `json:"user_id" parquet:"name=user_id, type=INT64"`
+	Name           string  `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	From           string  `json:"from" parquet:"name=from, type=BYTE_ARRAY, convertedtype=UTF8"`
+	To             string  `json:"to" parquet:"name=to, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ElapsedSeconds int64   `json:"elapsed_seconds" parquet:"name=elapsed_seconds, type=INT64"`
+	EnergyTrained  int64   `json:"energy_trained" parquet:"name=energy_trained, type=INT64"`
+	Gains          float64 `json:"gains" parquet:"name=gains, type=DOUBLE"`
+	Refills        int64   `json:"refills" parquet:"name=refills, type=INT64"`
+	EstimatedRegen int64   `json:"estimated_regen" parquet:"name=estimated_regen, type=INT64"`
+	DataGap        bool    `json:"data_gap" parquet:"name=data_gap, type=BOOLEAN"`
+	LowConfidence  bool    `json:"low_confidence" parquet:"name=low_confidence, type=BOOLEAN"`
+	Events         string  `json:"events" parquet:"name=events, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Diff           string  `json:"diff" parquet:"name=diff, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func NewDiffRecord(name string, diff model.UserDiff, from time.Time, to time.Time) (DiffRecord, error) {
+	events := diff.GetEvents()
+	if events == nil {
+		events = []model.Event{}
+	}
+	encodedEvents, err := json.Marshal(events)
+	if err != nil {
+		return DiffRecord{}, err
+	}
+	encodedDiff, err := json.Marshal(diff)
+	if err != nil {
+		return DiffRecord{}, err
+	}
+	gains, _ := diff.BattleStats.GetTotalGains().Float64()
+	return DiffRecord{
+		UserId:         int64(diff.UserId),
+		Name:           name,
+		From:           from.UTC().Format(time.RFC3339),
+		To:             to.UTC().Format(time.RFC3339),
+		ElapsedSeconds: int64(diff.Elapsed / time.Second),
+		EnergyTrained:  int64(diff.CalculateEnergyTrained()),
+		Gains:          gains,
+		Refills:        int64(diff.CalculateRefills().Total),
+		EstimatedRegen: int64(diff.EstimatedRegen),
+		DataGap:        diff.DataGap,
+		LowConfidence:  diff.LowConfidence,
+		Events:         string(encodedEvents),
+		Diff:           string(encodedDiff),
+	}, nil
+}
+
+type SummaryRecord struct {
+	Rank               int64   `json:"rank" parquet:"name=rank, type=INT64"`
+	UserId             int64   `json:"user_id" parquet:"name=user_id, type=INT64"`
+	Name               string  `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Energy             int64   `json:"energy" parquet:"name=energy, type=INT64"`
+	FHCs               int64   `json:"fhcs" parquet:"name=fhcs, type=INT64"`
+	Xanax              int64   `json:"xanax" parquet:"name=xanax, type=INT64"`
+	LSD                int64   `json:"lsd" parquet:"name=lsd, type=INT64"`
+	EnergyDrinks       int64   `json:"energy_drinks" parquet:"name=energy_drinks, type=INT64"`
+	EnergyRefills      int64   `json:"energy_refills" parquet:"name=energy_refills, type=INT64"`
+	SpecialRefills     int64   `json:"special_refills" parquet:"name=special_refills, type=INT64"`
+	EDVDs              int64   `json:"edvds" parquet:"name=edvds, type=INT64"`
+	JpEnergy           int64   `json:"jp_energy" parquet:"name=jp_energy, type=INT64"`
+	Attacks            int64   `json:"attacks" parquet:"name=attacks, type=INT64"`
+	Overdoses          int64   `json:"overdoses" parquet:"name=overdoses, type=INT64"`
+	TotalGains         float64 `json:"total_gains" parquet:"name=total_gains, type=DOUBLE"`
+	GainsPerKiloEnergy float64 `json:"gains_per_kilo_energy" parquet:"name=gains_per_kilo_energy, type=DOUBLE"`
+	NerveSpent         int64   `json:"nerve_spent" parquet:"name=nerve_spent, type=INT64"`
+	Crimes             int64   `json:"crimes" parquet:"name=crimes, type=INT64"`
+	DataGaps           int64   `json:"data_gaps" parquet:"name=data_gaps, type=INT64"`
+	Score              float64 `json:"score" parquet:"name=score, type=DOUBLE"`
+	ScoreMode          string  `json:"score_mode" parquet:"name=score_mode, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func NewSummaryRecord(rank int, s model.UserSummary) SummaryRecord {
+	return SummaryRecord{
+		Rank:               int64(rank + 1),
+		UserId:             int64(s.User),
+		Name:               s.Name,
+		Energy:             int64(s.Energy),
+		FHCs:               int64(s.FHCs),
+		Xanax:              int64(s.Xanax),
+		LSD:                int64(s.LSD),
+		EnergyDrinks:       int64(s.EnergyDrinks),
+		EnergyRefills:      int64(s.EnergyRefills),
+		SpecialRefills:     int64(s.SpecialRefills),
+		EDVDs:              int64(s.EDVDs),
+		JpEnergy:           int64(s.JpEnergy),
+		Attacks:            int64(s.Attacks),
+		Overdoses:          int64(s.Overdoses),
+		TotalGains:         s.TotalGains,
+		GainsPerKiloEnergy: s.GainsPerKiloEnergy,
+		NerveSpent:         int64(s.NerveSpent),
+		Crimes:             int64(s.Crimes),
+		DataGaps:           int64(s.DataGaps),
+		Score:              s.Score,
+		ScoreMode:          s.ScoreMode,
+	}
+}
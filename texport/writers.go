@@ -0,0 +1,139 @@
+package texport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJsonLines = "jsonl"
+	FormatCsv       = "csv"
+	FormatParquet   = "parquet"
+)
+
+var ContentTypes = map[string]string{
+	FormatJsonLines: "application/x-ndjson",
+	FormatCsv:       "text/csv",
+	FormatParquet:   "application/vnd.apache.parquet",
+}
+
+func IsFormat(format string) bool {
+	_, ok := ContentTypes[format]
+	return ok
+}
+
+// Writes records of a single type as they're produced; Close flushes whatever is buffered
+type RecordWriter interface {
+	Write(record interface{}) error
+	Close() error
+}
+
+// prototype is a pointer to the record type, used for CSV headers and the Parquet schema
+func NewRecordWriter(format string, prototype interface{}, w io.Writer) (RecordWriter, error) {
+	switch format {
+	case FormatJsonLines:
+		return &jsonLinesWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatCsv:
+		return newCsvWriter(prototype, w)
+	case FormatParquet:
+		return newParquetWriter(prototype, w)
+	default:
+		return nil, errors.New("invalid format, expected one of: [jsonl, csv, parquet]")
+	}
+}
+
+type jsonLinesWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonLinesWriter) Write(record interface{}) error {
+	return j.encoder.Encode(record)
+}
+
+func (j *jsonLinesWriter) Close() error {
+	return nil
+}
+
+// Rows this many records apart are flushed so responses stream
+const csvFlushEvery = 100
+
+type csvWriter struct {
+	writer  *csv.Writer
+	written int
+}
+
+func columnName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+func newCsvWriter(prototype interface{}, w io.Writer) (*csvWriter, error) {
+	t := reflect.TypeOf(prototype).Elem()
+	header := make([]string, t.NumField())
+	for i := range header {
+		header[i] = columnName(t.Field(i))
+	}
+	c := &csvWriter{writer: csv.NewWriter(w)}
+	return c, c.writer.Write(header)
+}
+
+func (c *csvWriter) Write(record interface{}) error {
+	v := reflect.ValueOf(record)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	row := make([]string, v.NumField())
+	for i := range row {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Float64:
+			row[i] = strconv.FormatFloat(field.Float(), 'f', -1, 64)
+		default:
+			row[i] = fmt.Sprint(field.Interface())
+		}
+	}
+	if err := c.writer.Write(row); err != nil {
+		return err
+	}
+	c.written += 1
+	if c.written%csvFlushEvery == 0 {
+		c.writer.Flush()
+		return c.writer.Error()
+	}
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// Row groups are held in memory until full, so they're kept small
+const parquetRowGroupSize = 8 * 1024 * 1024
+
+type parquetWriter struct {
+	writer *writer.ParquetWriter
+}
+
+func newParquetWriter(prototype interface{}, w io.Writer) (*parquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, prototype, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	return &parquetWriter{writer: pw}, nil
+}
+
+func (p *parquetWriter) Write(record interface{}) error {
+	return p.writer.Write(record)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.writer.WriteStop()
+}
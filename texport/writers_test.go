@@ -0,0 +1,56 @@
+package texport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"torn/model"
+)
+
+func TestNewRecordWriter(t *testing.T) {
+	records := []SummaryRecord{
+		NewSummaryRecord(0, model.UserSummary{User: 1, Name: "Epi", Energy: 1500, Xanax: 6, TotalGains: 1234.5}),
+		NewSummaryRecord(1, model.UserSummary{User: 2, Name: "Bob, the builder", Energy: 750}),
+	}
+	tests := []struct {
+		format string
+		check  func(out string) bool
+	}{
+		{FormatJsonLines, func(out string) bool {
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			return len(lines) == 2 && strings.HasPrefix(lines[0], `{"rank":1,"user_id":1,"name":"Epi","energy":1500`)
+		}},
+		{FormatCsv, func(out string) bool {
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			return len(lines) == 3 && strings.HasPrefix(lines[0], "rank,user_id,name,energy,") &&
+				strings.HasPrefix(lines[1], "1,1,Epi,1500,0,6,") && strings.Contains(lines[1], ",1234.5,") &&
+				strings.HasPrefix(lines[2], `2,2,"Bob, the builder",750,`)
+		}},
+		{FormatParquet, func(out string) bool {
+			return len(out) > 8 && strings.HasPrefix(out, "PAR1") && strings.HasSuffix(out, "PAR1")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewRecordWriter(tt.format, new(SummaryRecord), &buf)
+			if err != nil {
+				t.Fatalf("NewRecordWriter() error = %v", err)
+			}
+			for _, record := range records {
+				if err := w.Write(record); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if !tt.check(buf.String()) {
+				t.Errorf("unexpected %s output: %q", tt.format, buf.String())
+			}
+		})
+	}
+	if _, err := NewRecordWriter("xml", new(SummaryRecord), &bytes.Buffer{}); err == nil {
+		t.Errorf("NewRecordWriter() expected an error for an invalid format")
+	}
+}
@@ -0,0 +1,98 @@
+package thealth
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"torn/tlog"
+)
+
+type Check func() error
+
+// Liveness checks back /healthz (restart when failing); readiness checks back /readyz
+// (stop routing traffic when failing) and always include the liveness checks
+type Checker struct {
+	mux       sync.Mutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+	}
+}
+
+// Nil-safe so modes can run without health endpoints
+func (c *Checker) AddLiveness(name string, check Check) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.liveness[name] = check
+}
+
+func (c *Checker) AddReadiness(name string, check Check) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.readiness[name] = check
+}
+
+func run(checks map[string]Check, results map[string]string) bool {
+	healthy := true
+	for name, check := range checks {
+		if err := check(); err != nil {
+			results[name] = err.Error()
+			healthy = false
+		} else {
+			results[name] = "ok"
+		}
+	}
+	return healthy
+}
+
+func (c *Checker) Live() (bool, map[string]string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	results := make(map[string]string)
+	return run(c.liveness, results), results
+}
+
+func (c *Checker) Ready() (bool, map[string]string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	results := make(map[string]string)
+	live := run(c.liveness, results)
+	ready := run(c.readiness, results)
+	return live && ready, results
+}
+
+func writeResults(healthy bool, results map[string]string, w http.ResponseWriter) {
+	status := "ok"
+	statusCode := http.StatusOK
+	if !healthy {
+		status = "fail"
+		statusCode = http.StatusServiceUnavailable
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": results})
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to write %d response", statusCode)
+	}
+}
+
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	healthy, results := c.Live()
+	writeResults(healthy, results, w)
+}
+
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	healthy, results := c.Ready()
+	writeResults(healthy, results, w)
+}
@@ -0,0 +1,137 @@
+package thttp
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+)
+
+// Minimal server-rendered SVG charts, so pages need no JavaScript or external services
+
+type ChartSeries struct {
+	Label  string
+	Color  string
+	Values []float64
+}
+
+var chartColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f"}
+
+const (
+	chartPadLeft   = 60
+	chartPadRight  = 10
+	chartPadTop    = 24
+	chartPadBottom = 24
+)
+
+func formatChartValue(v float64) string {
+	abs := math.Abs(v)
+	switch {
+	case abs >= 1e9:
+		return fmt.Sprintf("%.1fb", v/1e9)
+	case abs >= 1e6:
+		return fmt.Sprintf("%.1fm", v/1e6)
+	case abs >= 1e4:
+		return fmt.Sprintf("%.1fk", v/1e3)
+	default:
+		return fmt.Sprintf("%.0f", v)
+	}
+}
+
+func seriesRange(series []ChartSeries, fromZero bool) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, v := range s.Values {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		return 0, 1
+	}
+	if fromZero && lo > 0 {
+		lo = 0
+	}
+	if hi == lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+func writeChartFrame(b *strings.Builder, title string, labels []string, lo float64, hi float64, width int, height int) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		width, height, width, height)
+	fmt.Fprintf(b, `<text x="%d" y="14" font-size="13">%s</text>`, chartPadLeft, html.EscapeString(title))
+	bottom := height - chartPadBottom
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, chartPadLeft, chartPadTop, chartPadLeft, bottom)
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, chartPadLeft, bottom, width-chartPadRight, bottom)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, chartPadLeft-4, chartPadTop+8, formatChartValue(hi))
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, chartPadLeft-4, bottom, formatChartValue(lo))
+	if len(labels) > 0 {
+		fmt.Fprintf(b, `<text x="%d" y="%d">%s</text>`, chartPadLeft, height-6, html.EscapeString(labels[0]))
+		fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, width-chartPadRight, height-6,
+			html.EscapeString(labels[len(labels)-1]))
+	}
+}
+
+func RenderBarChart(title string, labels []string, values []float64, width int, height int) string {
+	var b strings.Builder
+	lo, hi := seriesRange([]ChartSeries{{Values: values}}, true)
+	writeChartFrame(&b, title, labels, lo, hi, width, height)
+	plotWidth := float64(width - chartPadLeft - chartPadRight)
+	plotHeight := float64(height - chartPadTop - chartPadBottom)
+	if len(values) > 0 {
+		slot := plotWidth / float64(len(values))
+		for i, v := range values {
+			h := plotHeight * (v - lo) / (hi - lo)
+			x := float64(chartPadLeft) + slot*float64(i) + slot*0.1
+			y := float64(height-chartPadBottom) - h
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+				x, y, slot*0.8, h, chartColors[0], html.EscapeString(labels[i]), formatChartValue(v))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func linePoints(values []float64, lo float64, hi float64, left float64, top float64, plotWidth float64, plotHeight float64) string {
+	step := plotWidth
+	if len(values) > 1 {
+		step = plotWidth / float64(len(values)-1)
+	}
+	points := make([]string, len(values))
+	for i, v := range values {
+		points[i] = fmt.Sprintf("%.1f,%.1f", left+step*float64(i), top+plotHeight-plotHeight*(v-lo)/(hi-lo))
+	}
+	return strings.Join(points, " ")
+}
+
+func RenderLineChart(title string, labels []string, series []ChartSeries, width int, height int) string {
+	var b strings.Builder
+	lo, hi := seriesRange(series, false)
+	writeChartFrame(&b, title, labels, lo, hi, width, height)
+	plotWidth := float64(width - chartPadLeft - chartPadRight)
+	plotHeight := float64(height - chartPadTop - chartPadBottom)
+	legendX := width - chartPadRight
+	for i := len(series) - 1; i >= 0; i-- {
+		s := series[i]
+		color := s.Color
+		if color == "" {
+			color = chartColors[i%len(chartColors)]
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, color,
+			linePoints(s.Values, lo, hi, chartPadLeft, chartPadTop, plotWidth, plotHeight))
+		fmt.Fprintf(&b, `<text x="%d" y="14" text-anchor="end" fill="%s">%s</text>`, legendX, color, html.EscapeString(s.Label))
+		legendX -= 8 + 7*len(s.Label)
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// Axis-less line for table rows
+func RenderSparkline(values []float64, width int, height int) string {
+	lo, hi := seriesRange([]ChartSeries{{Values: values}}, true)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/></svg>`, width, height, width, height, chartColors[0],
+		linePoints(values, lo, hi, 1, 1, float64(width-2), float64(height-2)))
+}
@@ -1,15 +1,25 @@
 package thttp
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/patrickmn/go-cache"
-	"log"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 	"torn/model"
+	"torn/rethinkdb"
+	"torn/texport"
+	"torn/tlog"
+	"torn/tmetrics"
 	"torn/treporter"
+	"torn/twebhook"
 )
 
 type DateRange struct {
@@ -18,91 +28,951 @@ type DateRange struct {
 }
 
 func GetDateRangeForCompetition(in string) (*DateRange, error) {
-	times := []time.Time{
-		time.Date(2019, time.August, 24, 0, 0, 0, 0, time.UTC),
-		time.Date(2019, time.August, 31, 0, 0, 0, 0, time.UTC),
-		time.Date(2019, time.September, 7, 0, 0, 0, 0, time.UTC),
-	}
-	switch in {
-	case "1":
-		return &DateRange{times[0], times[1]}, nil
-	case "2":
-		return &DateRange{times[1], times[2]}, nil
-	case "overall":
-		return &DateRange{times[0], times[2]}, nil
-	default:
-		return nil, errors.New("invalid input, expected one of: [1, 2, overall]")
+	c, err := model.ActiveCompetitions.Get(in)
+	if err != nil {
+		return nil, err
 	}
+	return &DateRange{c.Begin, c.End}, nil
+}
 
+// Competition selected by ?week=, the current one by default
+func GetWeek(r *http.Request) string {
+	week := r.URL.Query().Get("week")
+	if week == "" {
+		week = model.ActiveCompetitions.Default(time.Now())
+	}
+	return week
 }
 
 type Server struct {
 	Cache *cache.Cache
 	Reporter *treporter.Reporter
+	GoalDao *rethinkdb.GoalDao
+	WebhookDao *rethinkdb.WebhookDao
+	AdminToken string // required by /admin endpoints when set
+	TornClient *TornClient // checks the API keys members manage their own goals with
+}
+
+func LeaderboardCacheKeys() []string {
+	return model.ActiveCompetitions.Ids()
+}
+
+func (s Server) CheckCachePopulated() error {
+	for _, key := range LeaderboardCacheKeys() {
+		if _, found := s.Cache.Get(key); !found {
+			return errors.New("leaderboard cache not populated: key=" + key)
+		}
+	}
+	return nil
 }
 
 func (s Server) RefreshCachePeriodically() {
-	cacheKeys := []string{"1", "2", "overall"}
 	go func() {
 		for {
-			for _, key := range cacheKeys {
-				log.Println("Starting ET cache update: key=" + key)
-				dateRange, _ := GetDateRangeForCompetition(key)
-				userEnergy, err := s.Reporter.CalculateEnergyTrained(dateRange.Begin, dateRange.End)
+			for _, key := range LeaderboardCacheKeys() {
+				tlog.WithField("cache_key", key).Debugf("Starting ET cache update")
+				competition, _ := model.ActiveCompetitions.Get(key)
+				userEnergy, anomalies, series, err := s.Reporter.CalculateEnergyTrainedDaily(competition.Begin, competition.End)
+				treporter.ApplyScoring(userEnergy, competition.Scoring)
 				if err != nil {
-					log.Printf("ERR: Unable to refresh cache (key=%s) on interval: %v", key, err)
+					tlog.WithError(err).WithField("cache_key", key).Errorf("Unable to refresh cache on interval")
 				} else {
-					log.Println("Successfully updated ET cache: key=" + key)
+					tlog.WithField("cache_key", key).Debugf("Successfully updated ET cache")
 					s.Cache.Set(key, userEnergy, cache.NoExpiration)
+					tmetrics.LeaderboardCacheAge.Updated(key)
+					s.Cache.Set("anomalies:"+key, anomalies, cache.NoExpiration)
+					s.Cache.Set("sparklines:"+key, RenderSparklines(series), cache.NoExpiration)
 				}
+				s.refreshTeams(key)
 			}
 			time.Sleep(time.Second * 5)
 		}
 	}()
 }
 
+func (s Server) refreshTeams(key string) {
+	competition, err := model.ActiveCompetitions.Get(key)
+	if err != nil || len(competition.Teams) == 0 {
+		return
+	}
+	teams, err := s.Reporter.CalculateTeams(*competition)
+	if err != nil {
+		tlog.WithError(err).WithField("cache_key", key).Errorf("Unable to refresh team cache on interval")
+		return
+	}
+	s.Cache.Set("teams:"+key, teams, cache.NoExpiration)
+}
+
+// Teams selected by ?team=: none when empty, every team for "all", or one team by name
+func GetTeam(r *http.Request) string {
+	return r.URL.Query().Get("team")
+}
+
+const AllTeams = "all"
+
+// Ranked teams of the competition from the cache, narrowed to the selected team
+func (s Server) getTeams(week string, board string, team string) ([]model.TeamSummary, int, error) {
+	competition, err := model.ActiveCompetitions.Get(week)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if len(competition.Teams) == 0 {
+		return nil, http.StatusNotFound, errors.New("competition has no teams")
+	}
+	if _, found := competition.Team(team); team != AllTeams && !found {
+		return nil, http.StatusNotFound, errors.New("invalid team: " + team)
+	}
+	cached, found := s.Cache.Get("teams:" + week)
+	if !found {
+		return nil, http.StatusServiceUnavailable, errors.New("team leaderboard not calculated yet")
+	}
+	teams := treporter.RankTeamsForBoard(cached.([]model.TeamSummary), board)
+	if team == AllTeams {
+		return teams, http.StatusOK, nil
+	}
+	for _, t := range teams {
+		if t.Name == team {
+			return []model.TeamSummary{t}, http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusNotFound, errors.New("invalid team: " + team)
+}
+
 func WritePlaintextResponse(statusCode int, message string, w http.ResponseWriter) {
 	w.WriteHeader(statusCode)
 	w.Header().Add("Content-Type", "plain/text")
 	_, err := w.Write([]byte(message))
 	if err != nil {
-		log.Printf("ERR: Unable to write %d response: %v", statusCode, err)
+		tlog.WithError(err).Errorf("Unable to write %d response", statusCode)
 	}
 }
 
 func (s Server) Handler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Page requested: %v\n", r.Header)
-	week := r.URL.Query().Get("week")
-	if week == "" {
-		week = "2"
-	}
+	// Headers carry the admin token, so only the path and agent are logged
+	tlog.WithFields(tlog.Fields{"path": r.URL.Path, "user_agent": r.UserAgent()}).Infof("Page requested")
+	week := GetWeek(r)
 	_, err := GetDateRangeForCompetition(week)
 	if err != nil {
 		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
 		return
 	}
+	board, err := GetBoard(r)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	if team := GetTeam(r); team != "" {
+		s.writeTeamsPlaintext(week, board, team, w)
+		return
+	}
 	var userSummary []model.UserSummary
 	cached, _ := s.Cache.Get(week)
 	if cached == nil {
 		WritePlaintextResponse(http.StatusInternalServerError, "Oops, something went horribly wrong. Please ping Epi :D", w)
 		return
 	}
-	userSummary = cached.([]model.UserSummary)
+	userSummary = treporter.RankForBoard(cached.([]model.UserSummary), board)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "plain/text")
-	prefixes := []string{"fhc", "xan", "prf", "lsd", "cans", "edvds", "jpEnergy", "attacks", "ods"}
 	for rank, ue := range userSummary {
-		var str1, str2 strings.Builder
-		values := []int{ue.FHCs, ue.Xanax, ue.EnergyRefills, ue.LSD, ue.EnergyDrinks, ue.EDVDs, ue.JpEnergy, ue.Attacks, ue.Overdoses}
-		for i, v := range values {
-			if v > 0 {
-				str2.WriteString(fmt.Sprintf("%s=%d, ", prefixes[i], v))
+		_, err := fmt.Fprintln(w, treporter.FormatBoardSummary(board, rank, ue))
+		if err != nil {
+			tlog.WithError(err).Errorf("Unable to write UserSummary to response")
+		}
+	}
+}
+
+// Team leaderboard, or one team's members when a team is named
+func (s Server) writeTeamsPlaintext(week string, board string, team string, w http.ResponseWriter) {
+	teams, status, err := s.getTeams(week, board, team)
+	if err != nil {
+		WritePlaintextResponse(status, err.Error(), w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Header().Add("Content-Type", "plain/text")
+	for rank, t := range teams {
+		lines := []string{treporter.FormatTeamSummary(board, rank, t)}
+		if team != AllTeams {
+			for memberRank, member := range t.Members {
+				lines = append(lines, "  "+treporter.FormatBoardSummary(board, memberRank, member))
+			}
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				tlog.WithError(err).Errorf("Unable to write TeamSummary to response")
 			}
 		}
-		str1.WriteString(fmt.Sprintf("#%d [%d (%s)] %d trained [%s]", rank+1, ue.User, ue.Name, ue.Energy, strings.TrimSuffix(str2.String(), ", ")))
-		_, err := fmt.Fprintln(w, str1.String())
+	}
+}
+
+// Leaderboard selected by ?board=; by default score for competitions with a scoring mode,
+// energy otherwise
+func GetBoard(r *http.Request) (string, error) {
+	board := r.URL.Query().Get("board")
+	if board == "" {
+		if c, err := model.ActiveCompetitions.Get(GetWeek(r)); err == nil && !c.Scoring.IsRaw() {
+			return treporter.BoardScore, nil
+		}
+		return treporter.BoardEnergy, nil
+	}
+	if !treporter.IsBoard(board) {
+		return "", errors.New("invalid board: " + board)
+	}
+	return board, nil
+}
+
+func WriteJsonResponse(statusCode int, body interface{}, w http.ResponseWriter) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to write %d response", statusCode)
+	}
+}
+
+func (s Server) LeaderboardApiHandler(w http.ResponseWriter, r *http.Request) {
+	week := GetWeek(r)
+	_, err := GetDateRangeForCompetition(week)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	board, err := GetBoard(r)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	if team := GetTeam(r); team != "" {
+		teams, status, err := s.getTeams(week, board, team)
 		if err != nil {
-			log.Printf("ERR: Unable to write UserSummary to response: %v", err)
+			WriteJsonResponse(status, map[string]string{"error": err.Error()}, w)
+		} else if team == AllTeams {
+			WriteJsonResponse(http.StatusOK, teams, w)
+		} else {
+			WriteJsonResponse(http.StatusOK, teams[0], w)
 		}
+		return
+	}
+	cached, _ := s.Cache.Get(week)
+	if cached == nil {
+		WriteJsonResponse(http.StatusServiceUnavailable, map[string]string{"error": "leaderboard not calculated yet"}, w)
+		return
+	}
+	WriteJsonResponse(http.StatusOK, treporter.RankForBoard(cached.([]model.UserSummary), board), w)
+}
+
+func (s Server) ChainHandler(w http.ResponseWriter, r *http.Request) {
+	week := GetWeek(r)
+	dateRange, err := GetDateRangeForCompetition(week)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	var chains []model.Chain
+	cacheKey := "chains:" + week
+	if cached, found := s.Cache.Get(cacheKey); found {
+		chains = cached.([]model.Chain)
+	} else {
+		chains, err = s.Reporter.CalculateChains(dateRange.Begin, dateRange.End)
+		if err != nil {
+			tlog.WithError(err).WithField("cache_key", week).Errorf("Unable to calculate chains")
+			WritePlaintextResponse(http.StatusInternalServerError, "Oops, something went horribly wrong. Please ping Epi :D", w)
+			return
+		}
+		s.Cache.Set(cacheKey, chains, time.Minute)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Header().Add("Content-Type", "plain/text")
+	for _, chain := range chains {
+		_, err := fmt.Fprintln(w, treporter.FormatChain(chain))
+		if err != nil {
+			tlog.WithError(err).Errorf("Unable to write Chain to response")
+		}
+	}
+}
+
+
+// Admin endpoints are closed when no token is configured
+func (s Server) IsAdmin(r *http.Request) bool {
+	if s.AdminToken == "" {
+		return false
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+func (s Server) AnomalyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.IsAdmin(r) {
+		WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"}, w)
+		return
+	}
+	week := GetWeek(r)
+	_, err := GetDateRangeForCompetition(week)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	cached, found := s.Cache.Get("anomalies:" + week)
+	if !found {
+		WriteJsonResponse(http.StatusServiceUnavailable, map[string]string{"error": "leaderboard not calculated yet"}, w)
+		return
+	}
+	anomalies := cached.([]model.Anomaly)
+	if anomalies == nil {
+		anomalies = []model.Anomaly{}
+	}
+	WriteJsonResponse(http.StatusOK, map[string]interface{}{"policy": s.Reporter.AnomalyPolicy, "anomalies": anomalies}, w)
+}
+
+
+func (s Server) ItemLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	week := GetWeek(r)
+	dateRange, err := GetDateRangeForCompetition(week)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	userId, err := strconv.ParseUint(r.URL.Query().Get("user"), 10, 32)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid user"}, w)
+		return
+	}
+	var ledger *model.ItemLedger
+	cacheKey := fmt.Sprintf("items:%s:%d", week, userId)
+	if cached, found := s.Cache.Get(cacheKey); found {
+		ledger = cached.(*model.ItemLedger)
+	} else {
+		ledger, err = s.Reporter.CalculateItemLedger(uint(userId), dateRange.Begin, dateRange.End)
+		if err != nil {
+			tlog.WithError(err).WithFields(tlog.Fields{"cache_key": week, "user_id": userId}).Errorf("Unable to calculate item ledger")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to calculate item ledger"}, w)
+			return
+		}
+		s.Cache.Set(cacheKey, ledger, time.Minute)
+	}
+	WriteJsonResponse(http.StatusOK, ledger, w)
+}
+
+func (s Server) HappyJumpHandler(w http.ResponseWriter, r *http.Request) {
+	week := GetWeek(r)
+	dateRange, err := GetDateRangeForCompetition(week)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	var jumps []model.HappyJump
+	cacheKey := "jumps:" + week
+	if cached, found := s.Cache.Get(cacheKey); found {
+		jumps = cached.([]model.HappyJump)
+	} else {
+		jumps, err = s.Reporter.CalculateHappyJumps(dateRange.Begin, dateRange.End)
+		if err != nil {
+			tlog.WithError(err).WithField("cache_key", week).Errorf("Unable to calculate happy jumps")
+			WritePlaintextResponse(http.StatusInternalServerError, "Oops, something went horribly wrong. Please ping Epi :D", w)
+			return
+		}
+		s.Cache.Set(cacheKey, jumps, time.Minute)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Header().Add("Content-Type", "plain/text")
+	for _, jump := range jumps {
+		_, err := fmt.Fprintln(w, treporter.FormatHappyJump(jump))
+		if err != nil {
+			tlog.WithError(err).Errorf("Unable to write HappyJump to response")
+		}
+	}
+}
+
+// Range from ?from= and ?to= (YYYY-MM-DD, to exclusive), or the competition in ?week=
+func GetExportDateRange(r *http.Request) (*DateRange, error) {
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from == "" && to == "" {
+		return GetDateRangeForCompetition(GetWeek(r))
+	}
+	begin, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, errors.New("invalid from, expected YYYY-MM-DD")
+	}
+	end, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, errors.New("invalid to, expected YYYY-MM-DD")
+	}
+	if !end.After(begin) {
+		return nil, errors.New("to must be after from")
+	}
+	return &DateRange{begin, end}, nil
+}
+
+// Streams ?kind= (snapshots, diffs or leaderboard) as ?format= (jsonl, csv or parquet)
+func (s Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.IsAdmin(r) {
+		WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"}, w)
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = texport.KindLeaderboard
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = texport.FormatJsonLines
+	}
+	if !texport.IsKind(kind) || !texport.IsFormat(format) {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid kind or format"}, w)
+		return
+	}
+	board, err := GetBoard(r)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	dateRange, err := GetExportDateRange(r)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	w.Header().Add("Content-Type", texport.ContentTypes[format])
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s-%s.%s\"", kind,
+		dateRange.Begin.Format("20060102"), dateRange.End.Format("20060102"), format))
+	w.WriteHeader(http.StatusOK)
+	exporter := texport.Exporter{Reporter: s.Reporter}
+	if c, err := model.ActiveCompetitions.Get(GetWeek(r)); err == nil && r.URL.Query().Get("from") == "" {
+		exporter.Scoring = c.Scoring
+	}
+	// Headers are already sent, so a failure part way through can only be logged
+	written, err := exporter.Export(kind, format, board, dateRange.Begin, dateRange.End, w)
+	if err != nil {
+		tlog.WithError(err).WithFields(tlog.Fields{"kind": kind, "format": format, "records": written}).Errorf("Unable to export")
+	}
+}
+
+type seriesRequest struct {
+	Week      string
+	DateRange *DateRange
+	Bucket    string
+	UserId    uint
+}
+
+// Reads ?week=, ?bucket= (day by default) and ?user=
+func parseSeriesRequest(r *http.Request) (*seriesRequest, error) {
+	req := seriesRequest{Week: GetWeek(r), Bucket: r.URL.Query().Get("bucket")}
+	if req.Bucket == "" {
+		req.Bucket = model.BucketDay
 	}
-}
\ No newline at end of file
+	var err error
+	if req.DateRange, err = GetDateRangeForCompetition(req.Week); err != nil {
+		return nil, err
+	}
+	if !model.IsBucket(req.Bucket) {
+		return nil, errors.New("invalid bucket, expected one of: [hour, day, week]")
+	}
+	userId, err := strconv.ParseUint(r.URL.Query().Get("user"), 10, 32)
+	if err != nil {
+		return nil, errors.New("invalid user")
+	}
+	req.UserId = uint(userId)
+	return &req, nil
+}
+
+func (s Server) getTimeSeries(req *seriesRequest) (*model.TimeSeries, error) {
+	cacheKey := fmt.Sprintf("timeseries:%s:%s:%d", req.Week, req.Bucket, req.UserId)
+	if cached, found := s.Cache.Get(cacheKey); found {
+		return cached.(*model.TimeSeries), nil
+	}
+	ts, err := s.Reporter.CalculateTimeSeries(req.UserId, req.Bucket, req.DateRange.Begin, req.DateRange.End)
+	if err != nil {
+		tlog.WithError(err).WithFields(tlog.Fields{"cache_key": cacheKey}).Errorf("Unable to calculate time series")
+		return nil, err
+	}
+	s.Cache.Set(cacheKey, ts, time.Minute)
+	return ts, nil
+}
+
+func (s Server) TimeSeriesApiHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseSeriesRequest(r)
+	if err != nil {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+		return
+	}
+	ts, err := s.getTimeSeries(req)
+	if err != nil {
+		WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to calculate time series"}, w)
+		return
+	}
+	WriteJsonResponse(http.StatusOK, ts, w)
+}
+
+func bucketLabel(t time.Time, bucket string) string {
+	switch bucket {
+	case model.BucketHour:
+		return t.Format("Jan 2 15:04")
+	case model.BucketWeek:
+		return "w/c " + t.Format("Jan 2")
+	default:
+		return t.Format("Jan 2")
+	}
+}
+
+const (
+	ChartEnergy    = "energy"
+	ChartStats     = "stats"
+	ChartSparkline = "sparkline"
+)
+
+func RenderTimeSeriesChart(ts *model.TimeSeries, chart string) (string, error) {
+	labels := make([]string, len(ts.Points))
+	energy := make([]float64, len(ts.Points))
+	stats := []ChartSeries{{Label: "total"}, {Label: "str"}, {Label: "spd"}, {Label: "dex"}, {Label: "def"}}
+	for i, p := range ts.Points {
+		labels[i] = bucketLabel(p.Start, ts.Bucket)
+		energy[i] = float64(p.Energy)
+		for j, v := range []float64{p.Total, p.Strength, p.Speed, p.Dexterity, p.Defense} {
+			stats[j].Values = append(stats[j].Values, v)
+		}
+	}
+	switch chart {
+	case ChartEnergy:
+		return RenderBarChart(fmt.Sprintf("Energy trained per %s", ts.Bucket), labels, energy, 640, 220), nil
+	case ChartStats:
+		return RenderLineChart("Battle stats", labels, stats, 640, 260), nil
+	case ChartSparkline:
+		return RenderSparkline(energy, 120, 24), nil
+	default:
+		return "", errors.New("invalid chart, expected one of: [energy, stats, sparkline]")
+	}
+}
+
+// Daily energy sparklines by User ID, for the leaderboard page to embed
+func RenderSparklines(series map[uint]*model.TimeSeries) map[uint]template.HTML {
+	sparklines := make(map[uint]template.HTML, len(series))
+	for userId, ts := range series {
+		svg, _ := RenderTimeSeriesChart(ts, ChartSparkline)
+		sparklines[userId] = template.HTML(svg)
+	}
+	return sparklines
+}
+
+// Serves a user's chart selected by ?chart= as an SVG image
+func (s Server) ChartHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseSeriesRequest(r)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	chart := r.URL.Query().Get("chart")
+	if chart == "" {
+		chart = ChartEnergy
+	}
+	ts, err := s.getTimeSeries(req)
+	if err != nil {
+		WritePlaintextResponse(http.StatusInternalServerError, "Oops, something went horribly wrong. Please ping Epi :D", w)
+		return
+	}
+	svg, err := RenderTimeSeriesChart(ts, chart)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	w.Header().Add("Content-Type", "image/svg+xml")
+	w.Header().Add("Cache-Control", "max-age=60")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, svg); err != nil {
+		tlog.WithError(err).Errorf("Unable to write chart to response")
+	}
+}
+
+var pageTemplates = template.Must(template.New("leaderboard").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>body{font-family:sans-serif;margin:2em}td,th{padding:2px 8px;text-align:left}td.n{text-align:right}</style></head>
+<body><h1>{{.Title}}</h1>
+{{if .Nav}}<p>{{range .Nav}}<a href="{{.Link}}">{{.Name}}</a> {{end}}</p>{{end}}
+<table><tr><th>#</th><th>Name</th><th>{{.Metric}}</th><th>Energy per day</th></tr>
+{{range .Rows}}<tr><td class="n">{{.Rank}}</td><td><a href="{{.Link}}">{{.Name}}</a></td><td class="n">{{.Value}}</td>
+<td>{{.Sparkline}}</td></tr>
+{{end}}</table></body></html>
+`))
+
+var _ = template.Must(pageTemplates.New("user").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Name}}</title>
+<style>body{font-family:sans-serif;margin:2em}</style></head>
+<body><h1>{{.Name}} [{{.UserId}}]</h1>
+<p>{{range .Buckets}}<a href="{{.Link}}">{{.Bucket}}</a> {{end}}</p>
+<div>{{.EnergyChart}}</div>
+<div>{{.StatsChart}}</div>
+<p><a href="{{.Back}}">Back to the leaderboard</a></p>
+</body></html>
+`))
+
+func writeHtmlResponse(name string, data interface{}, w http.ResponseWriter) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		tlog.WithError(err).WithField("template", name).Errorf("Unable to render page")
+		WritePlaintextResponse(http.StatusInternalServerError, "Oops, something went horribly wrong. Please ping Epi :D", w)
+		return
+	}
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := buf.WriteTo(w); err != nil {
+		tlog.WithError(err).Errorf("Unable to write page to response")
+	}
+}
+
+type leaderboardRow struct {
+	Rank      int
+	Name      string
+	Value     string
+	Link      string
+	Sparkline template.HTML
+}
+
+// Sparklines come from the cache filled by RefreshCachePeriodically, so rendering a page
+// never reads history
+func memberRows(week string, board string, summaries []model.UserSummary, sparklines map[uint]template.HTML) []leaderboardRow {
+	var rows []leaderboardRow
+	for rank, summary := range summaries {
+		name := summary.Name
+		if name == "" {
+			name = strconv.Itoa(int(summary.User))
+		}
+		query := url.Values{"week": {week}, "user": {strconv.Itoa(int(summary.User))}}
+		rows = append(rows, leaderboardRow{
+			Rank:      rank + 1,
+			Name:      name,
+			Value:     treporter.FormatBoardMetric(board, summary),
+			Link:      "/user?" + query.Encode(),
+			Sparkline: sparklines[summary.User],
+		})
+	}
+	return rows
+}
+
+// HTML leaderboard with a daily energy sparkline per member; ?team= switches to the team
+// leaderboard or one team's members
+func (s Server) LeaderboardPageHandler(w http.ResponseWriter, r *http.Request) {
+	week := GetWeek(r)
+	competition, err := model.ActiveCompetitions.Get(week)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	board, err := GetBoard(r)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	pageLink := func(team string) string {
+		query := url.Values{"week": {week}, "board": {board}}
+		if team != "" {
+			query.Set("team", team)
+		}
+		return "/leaderboard?" + query.Encode()
+	}
+	type navLink struct {
+		Name string
+		Link string
+	}
+	var nav []navLink
+	if len(competition.Teams) > 0 {
+		nav = append(nav, navLink{"Members", pageLink("")}, navLink{"Teams", pageLink(AllTeams)})
+		for _, team := range competition.Teams {
+			nav = append(nav, navLink{team.Name, pageLink(team.Name)})
+		}
+	}
+	title := fmt.Sprintf("%s %s leaderboard", competition.Name, board)
+	sparklines, _ := s.Cache.Get("sparklines:" + week)
+	sparklinesByUser, _ := sparklines.(map[uint]template.HTML)
+	var rows []leaderboardRow
+	if team := GetTeam(r); team != "" {
+		teams, status, err := s.getTeams(week, board, team)
+		if err != nil {
+			WritePlaintextResponse(status, err.Error(), w)
+			return
+		}
+		if team == AllTeams {
+			for rank, t := range teams {
+				rows = append(rows, leaderboardRow{Rank: rank + 1, Name: t.Name,
+					Value: treporter.FormatBoardMetric(board, t.Total), Link: pageLink(t.Name)})
+			}
+		} else {
+			title = fmt.Sprintf("%s %s: %s (%s)", competition.Name, board, team, treporter.FormatBoardMetric(board, teams[0].Total))
+			rows = memberRows(week, board, teams[0].Members, sparklinesByUser)
+		}
+	} else {
+		cached, _ := s.Cache.Get(week)
+		if cached == nil {
+			WritePlaintextResponse(http.StatusServiceUnavailable, "Leaderboard not calculated yet", w)
+			return
+		}
+		rows = memberRows(week, board, treporter.RankForBoard(cached.([]model.UserSummary), board), sparklinesByUser)
+	}
+	metric := board
+	if board == treporter.BoardScore {
+		metric = competition.Scoring.Describe()
+	}
+	writeHtmlResponse("leaderboard", map[string]interface{}{
+		"Title":  title,
+		"Metric": metric,
+		"Nav":    nav,
+		"Rows":   rows,
+	}, w)
+}
+
+// HTML page with a member's energy and stat charts
+func (s Server) UserPageHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseSeriesRequest(r)
+	if err != nil {
+		WritePlaintextResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	ts, err := s.getTimeSeries(req)
+	if err != nil {
+		WritePlaintextResponse(http.StatusInternalServerError, "Oops, something went horribly wrong. Please ping Epi :D", w)
+		return
+	}
+	energyChart, _ := RenderTimeSeriesChart(ts, ChartEnergy)
+	statsChart, _ := RenderTimeSeriesChart(ts, ChartStats)
+	type bucketLink struct {
+		Bucket string
+		Link   string
+	}
+	var buckets []bucketLink
+	for _, bucket := range []string{model.BucketHour, model.BucketDay, model.BucketWeek} {
+		query := url.Values{"week": {req.Week}, "user": {strconv.Itoa(int(req.UserId))}, "bucket": {bucket}}
+		buckets = append(buckets, bucketLink{bucket, "/user?" + query.Encode()})
+	}
+	name := ts.Name
+	if name == "" {
+		name = strconv.Itoa(int(req.UserId))
+	}
+	writeHtmlResponse("user", map[string]interface{}{
+		"Name":        name,
+		"UserId":      req.UserId,
+		"Buckets":     buckets,
+		"EnergyChart": template.HTML(energyChart),
+		"StatsChart":  template.HTML(statsChart),
+		"Back":        "/leaderboard?week=" + url.QueryEscape(req.Week),
+	}, w)
+}
+
+func (s Server) getGoalProgress(userId uint) ([]model.GoalProgress, error) {
+	cacheKey := fmt.Sprintf("goals:%d", userId)
+	if cached, found := s.Cache.Get(cacheKey); found {
+		return cached.([]model.GoalProgress), nil
+	}
+	goals, err := s.GoalDao.GetForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	progress := []model.GoalProgress{}
+	for _, goal := range goals {
+		p, err := s.Reporter.CalculateGoalProgress(goal, now)
+		if err != nil {
+			return nil, err
+		}
+		progress = append(progress, p)
+	}
+	s.Cache.Set(cacheKey, progress, time.Minute)
+	return progress, nil
+}
+
+// GET ?user= lists goals with their progress; POST creates a goal from the JSON body and
+// DELETE ?id= removes one, both admin only
+// Header members send their own Torn API key in to manage their goals
+const MemberKeyHeader = "X-Api-Key"
+
+// The Torn user the request's MemberKeyHeader belongs to, as the API reports it; 0 when the
+// key is missing or invalid. Checked keys are cached so each isn't looked up per request
+func (s Server) MemberId(r *http.Request) uint {
+	apiKey := r.Header.Get(MemberKeyHeader)
+	if apiKey == "" || s.TornClient == nil {
+		return 0
+	}
+	cacheKey := "member:" + tlog.KeyFingerprint(apiKey)
+	if cached, found := s.Cache.Get(cacheKey); found {
+		return cached.(uint)
+	}
+	user, tornErr, err := s.TornClient.GetUser(apiKey)
+	if err != nil || tornErr != nil || user == nil {
+		tlog.WithKey(apiKey).Warnf("Unable to check member API key")
+		return 0
+	}
+	s.Cache.Set(cacheKey, user.UserId, time.Minute*10)
+	return user.UserId
+}
+
+// Admins manage any goal, members only their own
+func (s Server) canManageGoals(r *http.Request, userId uint) bool {
+	return s.IsAdmin(r) || (userId != 0 && s.MemberId(r) == userId)
+}
+
+// GET ?user= lists a member's goal progress. POST creates a goal and DELETE ?id= removes
+// one, either with the admin token or the member's own API key in MemberKeyHeader
+func (s Server) GoalsApiHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId, err := strconv.ParseUint(r.URL.Query().Get("user"), 10, 32)
+		if err != nil {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid user"}, w)
+			return
+		}
+		progress, err := s.getGoalProgress(uint(userId))
+		if err != nil {
+			tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to calculate goal progress")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to calculate goal progress"}, w)
+			return
+		}
+		WriteJsonResponse(http.StatusOK, progress, w)
+	case http.MethodPost:
+		var goal model.Goal
+		if err := json.NewDecoder(r.Body).Decode(&goal); err != nil {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid goal: " + err.Error()}, w)
+			return
+		}
+		if !s.canManageGoals(r, goal.UserId) {
+			WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"}, w)
+			return
+		}
+		goal.Achieved = nil
+		if err := goal.Validate(); err != nil {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+			return
+		}
+		id, err := s.GoalDao.Insert(goal)
+		if err != nil {
+			tlog.WithError(err).WithField("user_id", goal.UserId).Errorf("Unable to insert goal")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to save goal"}, w)
+			return
+		}
+		goal.Id = id
+		s.Cache.Delete(fmt.Sprintf("goals:%d", goal.UserId))
+		WriteJsonResponse(http.StatusCreated, goal, w)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "missing id"}, w)
+			return
+		}
+		goal, err := s.GoalDao.Get(id)
+		if err != nil {
+			tlog.WithError(err).WithField("goal_id", id).Errorf("Unable to get goal")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to delete goal"}, w)
+			return
+		}
+		if goal == nil {
+			WriteJsonResponse(http.StatusNotFound, map[string]string{"error": "goal not found"}, w)
+			return
+		}
+		if !s.canManageGoals(r, goal.UserId) {
+			WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"}, w)
+			return
+		}
+		if err := s.GoalDao.Delete(id); err != nil {
+			tlog.WithError(err).WithField("goal_id", id).Errorf("Unable to delete goal")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to delete goal"}, w)
+			return
+		}
+		s.Cache.Delete(fmt.Sprintf("goals:%d", goal.UserId))
+		WriteJsonResponse(http.StatusOK, map[string]string{"deleted": id}, w)
+	default:
+		WriteJsonResponse(http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"}, w)
+	}
+}
+
+// GET lists subscriptions without their secrets; POST registers one from the JSON body,
+// generating a secret when none is given; DELETE ?id= removes one
+func (s Server) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.IsAdmin(r) {
+		WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"}, w)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions, err := s.WebhookDao.GetSubscriptions()
+		if err != nil {
+			tlog.WithError(err).Errorf("Unable to get webhook subscriptions")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to get webhooks"}, w)
+			return
+		}
+		for i := range subscriptions {
+			subscriptions[i].Secret = ""
+		}
+		if subscriptions == nil {
+			subscriptions = []model.WebhookSubscription{}
+		}
+		WriteJsonResponse(http.StatusOK, subscriptions, w)
+	case http.MethodPost:
+		var subscription model.WebhookSubscription
+		if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid webhook: " + err.Error()}, w)
+			return
+		}
+		if subscription.Secret == "" {
+			subscription.Secret = twebhook.NewSecret()
+		}
+		subscription.Created = time.Now()
+		if err := subscription.Validate(); err != nil {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": err.Error()}, w)
+			return
+		}
+		id, err := s.WebhookDao.Insert(subscription)
+		if err != nil {
+			tlog.WithError(err).Errorf("Unable to insert webhook subscription")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to save webhook"}, w)
+			return
+		}
+		subscription.Id = id
+		// The only time the secret is returned
+		WriteJsonResponse(http.StatusCreated, subscription, w)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "missing id"}, w)
+			return
+		}
+		if err := s.WebhookDao.Delete(id); err != nil {
+			tlog.WithError(err).WithField("subscription_id", id).Errorf("Unable to delete webhook subscription")
+			WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to delete webhook"}, w)
+			return
+		}
+		WriteJsonResponse(http.StatusOK, map[string]string{"deleted": id}, w)
+	default:
+		WriteJsonResponse(http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"}, w)
+	}
+}
+
+// Recent deliveries for ?id=, most recent first; ?limit= defaults to 100
+func (s Server) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.IsAdmin(r) {
+		WriteJsonResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"}, w)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "missing id"}, w)
+		return
+	}
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			WriteJsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid limit"}, w)
+			return
+		}
+	}
+	deliveries, err := s.WebhookDao.GetDeliveries(id, limit)
+	if err != nil {
+		tlog.WithError(err).WithField("subscription_id", id).Errorf("Unable to get webhook deliveries")
+		WriteJsonResponse(http.StatusInternalServerError, map[string]string{"error": "unable to get deliveries"}, w)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []model.WebhookDelivery{}
+	}
+	WriteJsonResponse(http.StatusOK, deliveries, w)
+}
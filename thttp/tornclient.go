@@ -4,10 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"time"
 	"torn/model"
+	"torn/tlog"
+	"torn/tmetrics"
 )
 
 const UserSelections = "bars,battlestats,jobpoints,personalstats,refills,basic,inventory"
@@ -57,6 +58,8 @@ func (ter *TornErrorResponse) GetError() *TornErrorExt {
 		return &TornErrorExt{"IncorrectKey", true, false}
 	case 5:
 		return &TornErrorExt{"TooManyRequests", false, true}
+	case 7:
+		return &TornErrorExt{"IncorrectIdEntityRelation", false, true}
 	case 8:
 		return &TornErrorExt{"IpBlock", false, true}
 	case 9:
@@ -67,19 +70,22 @@ func (ter *TornErrorResponse) GetError() *TornErrorExt {
 		return &TornErrorExt{"KeyChangeError", false, true}
 	case 12:
 		return &TornErrorExt{"KeyReadError", false, true}
+	case 16:
+		return &TornErrorExt{"AccessLevelNotHighEnough", false, true}
 	default:
 		return &TornErrorExt{"UnmappedError", false, false}
 	}
 }
 
-func (tc TornClient) GetUser(apiKey string) (*model.User, *TornErrorResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, "https://api.torn.com/user", nil)
+func (tc TornClient) get(endpoint string, selections string, apiKey string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://api.torn.com/"+endpoint, nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	defer tmetrics.ObserveSince(tmetrics.TornRequestDuration, endpoint, time.Now())
 
 	q := req.URL.Query()
-	q.Add("selections", UserSelections)
+	q.Add("selections", selections)
 	q.Add("key", apiKey)
 	req.URL.RawQuery = q.Encode()
 
@@ -87,23 +93,29 @@ func (tc TornClient) GetUser(apiKey string) (*model.User, *TornErrorResponse, er
 
 	resp, err := tc.Client.Do(req)
 	if err != nil {
-		return nil, nil, err
+		tmetrics.TornErrors.WithLabelValues("Transport").Inc()
+		return nil, err
 	}
 	if resp.Body != nil {
 		defer func() {
 			err := resp.Body.Close()
 			if err != nil {
-				log.Printf("Unable to close Response body: %s\n", err)
+				tlog.WithError(err).Warnf("Unable to close Response body")
 			}
 		}()
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (tc TornClient) GetUser(apiKey string) (*model.User, *TornErrorResponse, error) {
+	body, err := tc.get("user", UserSelections, apiKey)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	responseType, err := model.GetUserResponseType(body)
 	if err != nil {
+		tmetrics.TornErrors.WithLabelValues("Decode").Inc()
 		return nil, nil, err
 	}
 
@@ -120,8 +132,33 @@ func (tc TornClient) GetUser(apiKey string) (*model.User, *TornErrorResponse, er
 		if err != nil {
 			return nil, nil, err
 		}
+		tmetrics.TornErrors.WithLabelValues(errorResponse.GetError().Text).Inc()
 		return nil, &errorResponse, nil
 	} else {
 		return nil, nil, errors.New("Unexpected response type: " + *responseType)
 	}
 }
+
+// Requires a key with faction API access
+func (tc TornClient) GetFactionAttacks(apiKey string) ([]model.Attack, *TornErrorResponse, error) {
+	body, err := tc.get("faction", "attacks", apiKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errorResponse := TornErrorResponse{}
+	if err = json.Unmarshal(body, &errorResponse); err == nil && errorResponse.Error.Error != "" {
+		tmetrics.TornErrors.WithLabelValues(errorResponse.GetError().Text).Inc()
+		return nil, &errorResponse, nil
+	}
+	attacksResponse := model.AttacksResponse{}
+	if err = json.Unmarshal(body, &attacksResponse); err != nil {
+		tmetrics.TornErrors.WithLabelValues("Decode").Inc()
+		return nil, nil, err
+	}
+	attacks, err := attacksResponse.Attacks.ToAttacks()
+	if err != nil {
+		return nil, nil, err
+	}
+	return attacks, nil, nil
+}
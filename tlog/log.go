@@ -0,0 +1,134 @@
+package tlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type Fields = logrus.Fields
+
+// Torn passes the API key as a query parameter, so transport errors quote it in the URL
+var keyParam = regexp.MustCompile(`(?i)(key=)[A-Za-z0-9]+`)
+
+var redactor = &redactHook{secrets: make(map[string]string)}
+
+var Logger = newLogger()
+
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	logger.AddHook(redactor)
+	return logger
+}
+
+func Configure(level string, json bool) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Logger.SetLevel(lvl)
+	if json {
+		Logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		Logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+	return nil
+}
+
+// Stable, non-reversible identifier for an API key, safe for logs, metric labels and alerts
+func KeyFingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// Registered keys are replaced by their fingerprint wherever they appear in a log entry
+func RegisterSecret(apiKey string) {
+	if apiKey == "" {
+		return
+	}
+	redactor.mux.Lock()
+	defer redactor.mux.Unlock()
+	redactor.secrets[apiKey] = "key:" + KeyFingerprint(apiKey)
+}
+
+func Redact(s string) string {
+	return redactor.redact(s)
+}
+
+type redactHook struct {
+	mux     sync.RWMutex
+	secrets map[string]string
+}
+
+func (h *redactHook) redact(s string) string {
+	h.mux.RLock()
+	for secret, fingerprint := range h.secrets {
+		s = strings.ReplaceAll(s, secret, fingerprint)
+	}
+	h.mux.RUnlock()
+	return keyParam.ReplaceAllString(s, "${1}REDACTED")
+}
+
+func (h *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Entries are copied before hooks fire, so rewriting Data doesn't leak into the caller's fields
+func (h *redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redact(entry.Message)
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			entry.Data[k] = h.redact(value)
+		case error:
+			entry.Data[k] = h.redact(value.Error())
+		}
+	}
+	return nil
+}
+
+func WithKey(apiKey string) *logrus.Entry {
+	return Logger.WithField("key_fingerprint", KeyFingerprint(apiKey))
+}
+
+func WithField(key string, value interface{}) *logrus.Entry {
+	return Logger.WithField(key, value)
+}
+
+func WithFields(fields Fields) *logrus.Entry {
+	return Logger.WithFields(fields)
+}
+
+func WithError(err error) *logrus.Entry {
+	return Logger.WithError(err)
+}
+
+func Debugf(format string, args ...interface{}) {
+	Logger.Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	Logger.Infof(format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	Logger.Warnf(format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	Logger.Errorf(format, args...)
+}
+
+func Fatalf(format string, args ...interface{}) {
+	Logger.Fatalf(format, args...)
+}
+
+func Panicf(format string, args ...interface{}) {
+	Logger.Panicf(format, args...)
+}
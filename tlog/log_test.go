@@ -0,0 +1,37 @@
+package tlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	Logger.SetOutput(&buf)
+	if err := Configure("info", true); err != nil {
+		t.Fatal(err)
+	}
+	apiKey := "AbCdEfGh12345678"
+	RegisterSecret(apiKey)
+	WithKey(apiKey).WithError(errors.New("Get https://api.torn.com/user/?selections=bars&key=ZzZz9999YyYy8888: timeout")).
+		Errorf("Unable to fetch user for %s", apiKey)
+	WithField("user_id", 1).Debugf("User document: %s", apiKey)
+
+	out := buf.String()
+	if strings.Contains(out, apiKey) || strings.Contains(out, "ZzZz9999YyYy8888") {
+		t.Fatalf("log output leaked an API key: %s", out)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &entry); err != nil {
+		t.Fatalf("expected exactly one JSON entry, got %q: %v", out, err)
+	}
+	if entry["key_fingerprint"] != KeyFingerprint(apiKey) || entry["msg"] != "Unable to fetch user for key:"+KeyFingerprint(apiKey) {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+	if !strings.Contains(entry["error"].(string), "key=REDACTED") {
+		t.Errorf("error field not redacted: %v", entry["error"])
+	}
+}
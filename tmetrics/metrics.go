@@ -0,0 +1,104 @@
+package tmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	TornRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "torn_request_duration_seconds",
+		Help:    "Latency of Torn API requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	TornErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "torn_request_errors_total",
+		Help: "Failed Torn API requests, by TornErrorExt text or Transport/Decode.",
+	}, []string{"error"})
+
+	Polls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "producer_polls_total",
+		Help: "User polls per API key fingerprint, by result.",
+	}, []string{"key", "result"})
+
+	UserChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "producer_user_changes_total",
+		Help: "Polls where the User differed from the cached copy.",
+	})
+
+	KafkaDeliveryFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_delivery_failures_total",
+		Help: "Messages Kafka failed to accept or deliver.",
+	}, []string{"topic"})
+
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumer_lag_messages",
+		Help: "Messages between the last consumed offset and the high watermark.",
+	}, []string{"topic", "partition"})
+
+	ConsumerInsertFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_insert_failures_total",
+		Help: "Documents the consumer failed to write to RethinkDB.",
+	}, []string{"table"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_deliveries_total",
+		Help: "Webhook events sent to subscribers, by result after retries.",
+	}, []string{"result"})
+
+	ReporterDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reporter_computation_duration_seconds",
+		Help:    "Time taken by reporter computations.",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"computation"})
+
+	LeaderboardCacheAge = newCacheAgeCollector()
+)
+
+func ObserveSince(histogram *prometheus.HistogramVec, label string, start time.Time) {
+	histogram.WithLabelValues(label).Observe(time.Since(start).Seconds())
+}
+
+// Reports seconds since each leaderboard cache key was last refreshed
+type cacheAgeCollector struct {
+	mux     sync.Mutex
+	desc    *prometheus.Desc
+	updated map[string]time.Time
+}
+
+func newCacheAgeCollector() *cacheAgeCollector {
+	c := &cacheAgeCollector{
+		desc: prometheus.NewDesc("leaderboard_cache_age_seconds",
+			"Seconds since the leaderboard cache key was refreshed.", []string{"key"}, nil),
+		updated: make(map[string]time.Time),
+	}
+	prometheus.MustRegister(c)
+	return c
+}
+
+func (c *cacheAgeCollector) Updated(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.updated[key] = time.Now()
+}
+
+func (c *cacheAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *cacheAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for key, updated := range c.updated {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(updated).Seconds(), key)
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -2,120 +2,225 @@ package tproducer
 
 import (
 	"encoding/json"
+	"errors"
 	gcache "github.com/patrickmn/go-cache"
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
-	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
+	"torn/rethinkdb"
+	"torn/talert"
+	"torn/thealth"
 	"torn/thttp"
+	"torn/tlog"
+	"torn/tmetrics"
 )
 
 type Args struct {
 	BootstrapServer string
 	ApiKeys []string
+	AttacksApiKey string
+	RethinkdbServer string // loads keys registered through the Discord bot when set
+	Alert talert.Args
+	Health *thealth.Checker
 }
 
-func BlockingLogProducerEvents(producer *kafka.Producer) {
+func BlockingLogProducerEvents(producer *kafka.Producer, alerts *talert.Engine) {
 	for e := range producer.Events() {
 		switch ev := e.(type) {
 		case *kafka.Message:
 			if ev.TopicPartition.Error != nil {
-				log.Printf("Delivery failed: %v\n", ev.TopicPartition)
+				tlog.WithError(ev.TopicPartition.Error).Errorf("Delivery failed: %v", ev.TopicPartition)
+				alerts.Record(talert.SourceKafkaDelivery, false)
+				if ev.TopicPartition.Topic != nil {
+					tmetrics.KafkaDeliveryFailures.WithLabelValues(*ev.TopicPartition.Topic).Inc()
+				}
 			} else {
-				log.Printf("Delivered message to %v\n", ev.TopicPartition)
+				tlog.Debugf("Delivered message to %v", ev.TopicPartition)
+				alerts.Record(talert.SourceKafkaDelivery, true)
 			}
 			break
 		default:
-			log.Printf("Ignored event: %s\n", ev)
+			tlog.Debugf("Ignored event: %s", ev)
 			break
 		}
 	}
 }
 
-func SetUpProducer(bootstrapServer string) *kafka.Producer {
+func SetUpProducer(bootstrapServer string, alerts *talert.Engine) *kafka.Producer {
 	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": bootstrapServer})
 	if err != nil {
-		log.Printf("Failed to create producer: %s\n", err)
+		tlog.WithError(err).Errorf("Failed to create producer")
 		os.Exit(1)
 	}
 	// Delivery report handler for produced messages
-	go BlockingLogProducerEvents(producer)
+	go BlockingLogProducerEvents(producer, alerts)
 	return producer
 }
 
-func SetUpUserTickers(trackerUsers []TrackerUser, done chan bool) map[TrackerUser]*time.Ticker {
-	tickersByUser := make(map[TrackerUser]*time.Ticker)
+// Tickers per TrackerUser, nil once a job stops permanently. Shared by the pollers, the
+// exit watcher and the readiness check, so access is locked
+type Tickers struct {
+	mux    sync.Mutex
+	byUser map[TrackerUser]*time.Ticker
+}
+
+func (t *Tickers) Get(tu TrackerUser) *time.Ticker {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.byUser[tu]
+}
+
+// Stops the user's ticker for good
+func (t *Tickers) Stop(tu TrackerUser) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if ticker := t.byUser[tu]; ticker != nil {
+		ticker.Stop()
+	}
+	t.byUser[tu] = nil
+}
+
+func (t *Tickers) Active() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	activeTickers := 0
+	for _, ticker := range t.byUser {
+		if ticker != nil {
+			activeTickers += 1
+		}
+	}
+	return activeTickers
+}
+
+func SetUpUserTickers(trackerUsers []TrackerUser, done chan bool) *Tickers {
+	tickers := &Tickers{byUser: make(map[TrackerUser]*time.Ticker)}
 	for _, tu := range trackerUsers {
-		ticker := time.NewTicker(tu.Frequency)
-		tickersByUser[tu] = ticker
+		tickers.byUser[tu] = time.NewTicker(tu.Frequency)
 	}
 	// Replace this with chan latch
 	go func() {
 		for {
-			activeTickers := 0
-			for _, t := range tickersByUser {
-				if t != nil {
-					activeTickers += 1
-				}
-			}
-			if activeTickers == 0 {
-				log.Println("Signaling exit as all jobs have been stopped.")
+			if tickers.Active() == 0 {
+				tlog.Infof("Signaling exit as all jobs have been stopped.")
 				done <- true
 				return
 			}
 			time.Sleep(time.Millisecond * 100)
 		}
 	}()
-	return tickersByUser
+	return tickers
 }
 
-func RunProducer(bootstrapServer string, apiKeys []string, done chan bool) {
+func RunProducer(args Args, done chan bool) {
 	// Global setup
 	var trackerUsers []TrackerUser
+	tlog.RegisterSecret(args.AttacksApiKey)
+	apiKeys := args.ApiKeys
+	if args.RethinkdbServer != "" {
+		apiKeys = append(apiKeys, LoadStoredKeys(args.RethinkdbServer)...)
+	}
+	seen := make(map[string]bool)
 	for _, apiKey := range apiKeys {
+		if seen[apiKey] {
+			continue
+		}
+		seen[apiKey] = true
+		tlog.RegisterSecret(apiKey)
 		trackerUsers = append(trackerUsers, TrackerUser{apiKey, time.Second * 5})
 	}
 	var tornClient = thttp.NewTornClient()
 	var cache = gcache.New(gcache.NoExpiration, gcache.NoExpiration)
-	producer := SetUpProducer(bootstrapServer)
+	alerts := talert.NewEngineFromArgs(args.Alert)
+	alerts.Run(time.Second * 5)
+	producer := SetUpProducer(args.BootstrapServer, alerts)
 	defer producer.Close()
 
 	// Set up repeat poller per TrackerUser
-	tickersByUser := SetUpUserTickers(trackerUsers, done)
+	tickers := SetUpUserTickers(trackerUsers, done)
+
+	args.Health.AddLiveness("kafka", func() error {
+		_, err := producer.GetMetadata(nil, false, 2000)
+		return err
+	})
+	args.Health.AddReadiness("trackers", func() error {
+		if tickers.Active() == 0 {
+			return errors.New("no active TrackerUser tickers")
+		}
+		return nil
+	})
 
 	for _, tu := range trackerUsers {
 		go func(tu TrackerUser) {
-			ticker := tickersByUser[tu]
+			ticker := tickers.Get(tu)
 			for t := range ticker.C {
-				truncatedApiKey := tu.TornApiKey[:4]
-				log.Printf("Job started: %s at %s\n", truncatedApiKey, t)
-				userId, err := UpdateUser(tornClient, cache, producer, tu.TornApiKey)
+				fingerprint := tlog.KeyFingerprint(tu.TornApiKey)
+				logger := tlog.WithKey(tu.TornApiKey)
+				logger.Debugf("Job started at %s", t)
+				userId, err := UpdateUser(tornClient, cache, producer, alerts, tu.TornApiKey)
 				if err != nil {
+					tmetrics.Polls.WithLabelValues(fingerprint, "failure").Inc()
 					if errExt, ok := err.(*thttp.TornErrorExt); ok {
 						if errExt.Remove {
-							log.Printf("Job failed permanently: error=%s, key=%s\n", errExt.Text, truncatedApiKey)
-							ticker.Stop()
-							tickersByUser[tu] = nil
+							logger.WithField("error", errExt.Text).Warnf("Job failed permanently")
+							alerts.RemoveKey(fingerprint, errExt.Text)
+							tickers.Stop(tu)
 							return
 						} else if errExt.Delay {
-							log.Printf("Job failed, delay requested: error=%s, key=%s\n", errExt.Text, truncatedApiKey)
+							logger.WithField("error", errExt.Text).Warnf("Job failed, delay requested")
 							time.Sleep(tu.Frequency * 2)
 						}
 					} else {
-						log.Printf("Job failed: Unable to fetch user: %s\n", err)
+						logger.WithError(err).Errorf("Job failed: Unable to fetch user")
 					}
 				} else {
-					log.Printf("Job succeeded: user=%d\n", *userId)
+					tmetrics.Polls.WithLabelValues(fingerprint, "success").Inc()
+					logger.WithField("user_id", *userId).Debugf("Job succeeded")
 				}
 			}
 		}(tu)
 	}
 
+	if args.AttacksApiKey != "" {
+		go func() {
+			ticker := time.NewTicker(time.Second * 30)
+			for t := range ticker.C {
+				logger := tlog.WithKey(args.AttacksApiKey)
+				logger.Debugf("Attacks job started at %s", t)
+				published, err := UpdateAttacks(tornClient, cache, producer, alerts, args.AttacksApiKey)
+				if err != nil {
+					logger.WithError(err).Errorf("Attacks job failed")
+				} else {
+					logger.WithField("published", published).Infof("Attacks job succeeded")
+				}
+			}
+		}()
+	}
+
 	<-done
-	log.Println("Flushing Kafka producer before returning...")
+	tlog.Infof("Flushing Kafka producer before returning...")
 	unflushedEvents := producer.Flush(15000)
-	log.Printf("Flushed events: remaining=%d\n", unflushedEvents)
+	tlog.WithField("remaining", unflushedEvents).Infof("Flushed events")
+}
+
+// Keys registered through the Discord bot; new registrations are picked up on restart
+func LoadStoredKeys(rethinkdbServer string) []string {
+	session := rethinkdb.SetUpDb(rethinkdbServer)
+	defer session.Close()
+	keyDao := rethinkdb.KeyDao{Session: session}
+	keys, err := keyDao.GetAll()
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to load registered API keys")
+		return nil
+	}
+	var apiKeys []string
+	for _, key := range keys {
+		tlog.RegisterSecret(key.ApiKey)
+		apiKeys = append(apiKeys, key.ApiKey)
+	}
+	tlog.WithField("keys", len(apiKeys)).Infof("Loaded registered API keys")
+	return apiKeys
 }
 
 type TrackerUser struct {
@@ -123,20 +228,31 @@ type TrackerUser struct {
 	Frequency time.Duration `json:"frequency,omitempty"`
 }
 
-func UpdateUser(tornClient *thttp.TornClient, cache *gcache.Cache, producer *kafka.Producer, TornApiKey string) (userId *uint, err error) {
+// Torn responded but isn't serving data
+func IsTornDown(tornError *thttp.TornErrorResponse) bool {
+	return tornError.Error.Code == 0 || tornError.Error.Code == 9
+}
+
+func UpdateUser(tornClient *thttp.TornClient, cache *gcache.Cache, producer *kafka.Producer, alerts *talert.Engine, TornApiKey string) (userId *uint, err error) {
 	// Get User
 	user, tornError, err := tornClient.GetUser(TornApiKey)
 	if err != nil {
+		alerts.Record(talert.SourceTorn, false)
 		return nil, err
 	} else if tornError != nil {
+		alerts.Record(talert.SourceTorn, !IsTornDown(tornError))
 		return nil, tornError.GetError()
 	}
+	alerts.Record(talert.SourceTorn, true)
 	userKey := strconv.FormatUint(uint64(user.UserId), 10)
 
 	cachedUser, _ := cache.Get(userKey)
 	cache.Set(userKey, *user, gcache.NoExpiration)
 	if !user.Equals(cachedUser) {
-		log.Printf("User updated:\n  Old:%+v\n  New:%+v\n\n", cachedUser, *user)
+		tmetrics.UserChanges.Inc()
+		logger := tlog.WithField("user_id", user.UserId)
+		logger.Infof("User updated")
+		logger.Debugf("User updated: old=%+v, new=%+v", cachedUser, *user)
 		// Produce messages to topic (asynchronously)
 		userJson, err := json.Marshal(user)
 		if err != nil {
@@ -149,8 +265,49 @@ func UpdateUser(tornClient *thttp.TornClient, cache *gcache.Cache, producer *kaf
 			Value:          userJson,
 		}, nil)
 		if err != nil {
+			alerts.Record(talert.SourceKafkaDelivery, false)
+			tmetrics.KafkaDeliveryFailures.WithLabelValues(topic).Inc()
 			return &user.UserId, err
 		}
 	}
+	alerts.Record(talert.SourcePoll, true)
 	return &user.UserId, nil
-}
\ No newline at end of file
+}
+
+func UpdateAttacks(tornClient *thttp.TornClient, cache *gcache.Cache, producer *kafka.Producer, alerts *talert.Engine, apiKey string) (int, error) {
+	attacks, tornError, err := tornClient.GetFactionAttacks(apiKey)
+	if err != nil {
+		alerts.Record(talert.SourceTorn, false)
+		return 0, err
+	} else if tornError != nil {
+		alerts.Record(talert.SourceTorn, !IsTornDown(tornError))
+		return 0, tornError.GetError()
+	}
+	alerts.Record(talert.SourceTorn, true)
+	published := 0
+	for _, attack := range attacks {
+		attackKey := strconv.FormatUint(uint64(attack.Id), 10)
+		if _, seen := cache.Get("attack:" + attackKey); seen {
+			continue
+		}
+		attackJson, err := json.Marshal(attack)
+		if err != nil {
+			return published, err
+		}
+		topic := "TornAttacks"
+		err = producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Key:            []byte(attackKey),
+			Value:          attackJson,
+		}, nil)
+		if err != nil {
+			alerts.Record(talert.SourceKafkaDelivery, false)
+			tmetrics.KafkaDeliveryFailures.WithLabelValues(topic).Inc()
+			return published, err
+		}
+		// The endpoint only returns recent attacks, so a day is plenty to dedupe
+		cache.Set("attack:"+attackKey, true, time.Hour*24)
+		published += 1
+	}
+	return published, nil
+}
@@ -0,0 +1,109 @@
+package treporter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"torn/model"
+)
+
+// Leaderboards ranking the same UserSummaries by different metrics
+const (
+	BoardEnergy = "energy"
+	BoardNerve  = "nerve"
+	BoardCrimes = "crimes"
+	BoardScore  = "score" // the competition's scoring mode
+)
+
+var boardMetrics = map[string]func(model.UserSummary) float64{
+	BoardEnergy: func(s model.UserSummary) float64 { return float64(s.Energy) },
+	BoardNerve:  func(s model.UserSummary) float64 { return float64(s.NerveSpent) },
+	BoardCrimes: func(s model.UserSummary) float64 { return float64(s.Crimes) },
+	BoardScore:  func(s model.UserSummary) float64 { return s.Score },
+}
+
+// Value the board ranks summary by
+func BoardMetric(board string, summary model.UserSummary) float64 {
+	return boardMetrics[board](summary)
+}
+
+func FormatBoardMetric(board string, summary model.UserSummary) string {
+	if board == BoardScore {
+		return fmt.Sprintf("%.2f", summary.Score)
+	}
+	return fmt.Sprintf("%.0f", BoardMetric(board, summary))
+}
+
+// Scores summaries in place under the competition's scoring
+func ApplyScoring(summaries []model.UserSummary, scoring model.Scoring) {
+	for i := range summaries {
+		summaries[i].Score = scoring.Score(summaries[i])
+		summaries[i].ScoreMode = scoring.Describe()
+	}
+}
+
+func IsBoard(board string) bool {
+	_, ok := boardMetrics[board]
+	return ok
+}
+
+// Copies and ranks summaries for the board; members who didn't take part are left off boards
+// other than energy and score
+func RankForBoard(summaries []model.UserSummary, board string) []model.UserSummary {
+	metric := boardMetrics[board]
+	ranked := make([]model.UserSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		if board == BoardEnergy || board == BoardScore || metric(summary) > 0 {
+			ranked = append(ranked, summary)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return metric(ranked[i]) > metric(ranked[j])
+	})
+	return ranked
+}
+
+// Copies and ranks teams by their totals on the board, ranking each team's members too
+func RankTeamsForBoard(teams []model.TeamSummary, board string) []model.TeamSummary {
+	metric := boardMetrics[board]
+	ranked := make([]model.TeamSummary, len(teams))
+	for i, team := range teams {
+		team.Members = RankForBoard(team.Members, board)
+		ranked[i] = team
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return metric(ranked[i].Total) > metric(ranked[j].Total)
+	})
+	return ranked
+}
+
+func FormatTeamSummary(board string, rank int, team model.TeamSummary) string {
+	if board == BoardScore {
+		return fmt.Sprintf("#%d [%s] %.2f %s from %d members", rank+1, team.Name, team.Total.Score, team.Total.ScoreMode, len(team.Members))
+	}
+	return fmt.Sprintf("#%d [%s] %.0f %s from %d members", rank+1, team.Name, BoardMetric(board, team.Total), board, len(team.Members))
+}
+
+func FormatBoardSummary(board string, rank int, ue model.UserSummary) string {
+	switch board {
+	case BoardScore:
+		return fmt.Sprintf("#%d [%d (%s)] %.2f %s [%d trained, max energy %d]", rank+1, ue.User, ue.Name, ue.Score, ue.ScoreMode,
+			ue.Energy, ue.MaxEnergy)
+	case BoardNerve:
+		return fmt.Sprintf("#%d [%d (%s)] %d nerve spent [refills=%d]", rank+1, ue.User, ue.Name, ue.NerveSpent, ue.NerveRefills)
+	case BoardCrimes:
+		var categories []string
+		for category, count := range ue.CrimeCounts {
+			categories = append(categories, fmt.Sprintf("%s=%d", category, count))
+		}
+		sort.Strings(categories)
+		var perCrime float64
+		if ue.Crimes > 0 {
+			perCrime = float64(ue.NerveSpent) / float64(ue.Crimes)
+		}
+		return fmt.Sprintf("#%d [%d (%s)] %d crimes, %d nerve (%.1f per crime) [%s] busts=%d (%d failed), jailed=%d", rank+1, ue.User,
+			ue.Name, ue.Crimes, ue.NerveSpent, perCrime, strings.Join(categories, ", "), ue.Busts, ue.FailedBusts, ue.Jailed)
+	default:
+		return FormatSummary(rank, ue)
+	}
+}
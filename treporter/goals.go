@@ -0,0 +1,122 @@
+package treporter
+
+import (
+	"fmt"
+	"time"
+	"torn/model"
+	"torn/rethinkdb"
+	"torn/talert"
+	"torn/tlog"
+	"torn/tmetrics"
+)
+
+// Energy goals count energy trained since Begin the same way the leaderboard does; stat
+// goals compare the latest battle stats against the first snapshot. Rates come from the
+// last model.GoalRateWindow
+func (r Reporter) CalculateGoalProgress(goal model.Goal, now time.Time) (model.GoalProgress, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "goalProgress", time.Now())
+	latest := now
+	if goal.End.Before(latest) {
+		latest = goal.End
+	}
+	userData, err := r.UserDao.GetInRange(int64(goal.UserId), goal.Begin, latest)
+	if err != nil {
+		return model.GoalProgress{}, err
+	}
+	windowStart := latest.Add(-model.GoalRateWindow)
+	if windowStart.Before(goal.Begin) {
+		windowStart = goal.Begin
+	}
+	recent := userData
+	for i, snapshot := range userData {
+		if !snapshot.Timestamp.Before(windowStart) {
+			// Include the snapshot before the window so the first diff in it is counted
+			if i > 0 {
+				i--
+			}
+			recent = userData[i:]
+			break
+		}
+	}
+	var start, current, gained float64
+	switch goal.Type {
+	case model.GoalStat:
+		if len(userData) > 0 {
+			start = model.StatValue(userData[0].Document.BattleStats, goal.Stat)
+			current = model.StatValue(userData[len(userData)-1].Document.BattleStats, goal.Stat)
+		}
+		if len(recent) > 0 {
+			gained = current - model.StatValue(recent[0].Document.BattleStats, goal.Stat)
+		}
+	default:
+		var total, window model.UserSummary
+		r.addToSummary(&total, userData, nil)
+		r.addToSummary(&window, recent, nil)
+		current = float64(total.Energy)
+		gained = float64(window.Energy)
+	}
+	var rate float64
+	if len(recent) > 1 {
+		days := recent[len(recent)-1].Timestamp.Sub(recent[0].Timestamp).Hours() / 24
+		if days > 0 {
+			rate = gained / days
+		}
+	}
+	return model.NewGoalProgress(goal, start, current, rate, now), nil
+}
+
+// Marks open goals achieved once they're hit and notifies
+type GoalChecker struct {
+	Reporter  *Reporter
+	GoalDao   *rethinkdb.GoalDao
+	Notifiers []talert.Notifier
+}
+
+func (c GoalChecker) Check(now time.Time) {
+	goals, err := c.GoalDao.GetOpen()
+	if err != nil {
+		tlog.WithError(err).Errorf("Unable to get open goals")
+		return
+	}
+	for _, goal := range goals {
+		if now.Before(goal.Begin) || now.After(goal.End.Add(time.Hour)) {
+			continue
+		}
+		progress, err := c.Reporter.CalculateGoalProgress(goal, now)
+		if err != nil {
+			tlog.WithError(err).WithField("goal_id", goal.Id).Errorf("Unable to calculate goal progress")
+			continue
+		}
+		if !progress.Done {
+			continue
+		}
+		if err := c.GoalDao.MarkAchieved(goal.Id, now); err != nil {
+			tlog.WithError(err).WithField("goal_id", goal.Id).Errorf("Unable to mark goal achieved")
+			continue
+		}
+		alert := talert.Alert{
+			Rule:    "goal",
+			Key:     "goal:" + goal.Id,
+			Message: fmt.Sprintf("User %d hit their goal: %s", goal.UserId, goal.Describe()),
+			Time:    now,
+		}
+		for _, notifier := range c.Notifiers {
+			if err := notifier.Notify(alert); err != nil {
+				tlog.WithError(err).WithField("goal_id", goal.Id).Errorf("Unable to send goal notification")
+			}
+		}
+	}
+}
+
+func (c GoalChecker) Run(interval time.Duration, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			c.Check(now)
+		}
+	}
+}
@@ -2,53 +2,88 @@ package treporter
 
 import (
 	"fmt"
-	"log"
 	"sort"
+	"strings"
 	"time"
 	"torn/model"
 	"torn/rethinkdb"
+	"torn/tlog"
+	"torn/tmetrics"
 )
 
 type Args struct {
 	RethinkdbServer string
+	FactionId       uint
+	Chains          bool
+	AnomalyPolicy   string
 }
 
 type Reporter struct {
-	UserDao *rethinkdb.UserDao
+	UserDao       *rethinkdb.UserDao
+	AttackDao     *rethinkdb.AttackDao
+	FactionId     uint
+	AnomalyPolicy string // one of model.AnomalyPolicy*; include when empty
 }
 
-func (r Reporter) CalculateEnergyTrained(earliest time.Time, latest time.Time) ([]model.UserSummary, error) {
+func (r Reporter) CalculateChains(earliest time.Time, latest time.Time) ([]model.Chain, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "chains", time.Now())
+	start := time.Now()
+	attacks, err := r.AttackDao.GetInRange(earliest, latest)
+	tlog.Debugf("GetInRange (attacks) took: %s", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	return model.BuildChains(attacks, r.FactionId), nil
+}
+
+func (r Reporter) CalculateEnergyTrained(earliest time.Time, latest time.Time) ([]model.UserSummary, []model.Anomaly, error) {
+	return r.calculateEnergyTrained(earliest, latest, nil)
+}
+
+// Also returns each User's daily energy series, built in the same pass over their history
+// so callers don't need a range scan per User
+func (r Reporter) CalculateEnergyTrainedDaily(earliest time.Time, latest time.Time) ([]model.UserSummary, []model.Anomaly, map[uint]*model.TimeSeries, error) {
+	series := make(map[uint]*model.TimeSeries)
+	summaries, anomalies, err := r.calculateEnergyTrained(earliest, latest, series)
+	return summaries, anomalies, series, err
+}
+
+// Fills series by User ID when it isn't nil
+func (r Reporter) calculateEnergyTrained(earliest time.Time, latest time.Time, series map[uint]*model.TimeSeries) ([]model.UserSummary, []model.Anomaly, error) {
 	summaries := make(map[uint]*model.UserSummary)
+	var anomalies []model.Anomaly
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "energyTrained", time.Now())
 	start := time.Now()
 	userIds, err := r.UserDao.GetUserIds()
 	elapsed := time.Since(start)
-	log.Printf("GetUserIds took: %s\n", elapsed)
+	tmetrics.ReporterDuration.WithLabelValues("getUserIds").Observe(elapsed.Seconds())
+	tlog.Debugf("GetUserIds took: %s", elapsed)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	log.Printf("Found %d distinct User IDs: %v", len(userIds), userIds)
+	tlog.Infof("Found %d distinct User IDs", len(userIds))
+	tlog.Debugf("User IDs: %v", userIds)
 	// Init UserSummaries
 	for _, userId := range userIds {
 		summaries[uint(userId)] = &model.UserSummary{User: uint(userId)}
 	}
 	for _, userId := range userIds {
-		userData, err := r.UserDao.GetInRange(userId, earliest, latest)
+		var ts *model.TimeSeries
+		if series != nil {
+			ts, _ = model.NewTimeSeries(uint(userId), model.BucketDay, earliest, latest)
+			series[uint(userId)] = ts
+		}
+		userAnomalies, err := r.addUserSummary(summaries[uint(userId)], userId, earliest, latest, ts)
+		if ts != nil {
+			ts.Finish()
+		}
 		if err != nil {
-			log.Printf("ERR: Unable to get history for User: id=%d, err=%s\n", userId, err)
-		}
-		for i := 0; i < len(userData)-1; i++ {
-			prev := userData[i]
-			next := userData[i+1]
-			udiff := prev.Document.Diff(next.Document)
-			udiff.AddToSummary(summaries[prev.Document.UserId])
-		}
-		for i := len(userData)-1; i >= 0; i-- {
-			cur := userData[i]
-			if cur.Document.Name != "" {
-				summaries[cur.Document.UserId].Name = cur.Document.Name
-				break
-			}
+			tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to get history for User")
 		}
+		anomalies = append(anomalies, userAnomalies...)
+	}
+	for i := range anomalies {
+		anomalies[i].Name = summaries[anomalies[i].UserId].Name
 	}
 	var result []model.UserSummary
 	for _, summary := range summaries {
@@ -57,7 +92,243 @@ func (r Reporter) CalculateEnergyTrained(earliest time.Time, latest time.Time) (
 	sort.SliceStable(result, func(i, j int) bool {
 		return result[i].Energy > result[j].Energy
 	})
-	return result, nil
+	return result, anomalies, nil
+}
+
+func (r Reporter) addUserSummary(summary *model.UserSummary, userId int64, earliest time.Time, latest time.Time, ts *model.TimeSeries) ([]model.Anomaly, error) {
+	userData, err := r.UserDao.GetInRange(userId, earliest, latest)
+	anomalies := r.addToSummary(summary, userData, ts)
+	for i := len(userData)-1; i >= 0; i-- {
+		if name := userData[i].Document.Name; name != "" {
+			summary.Name = name
+			break
+		}
+	}
+	return anomalies, err
+}
+
+// Events from consecutive snapshots, oldest first
+func (r Reporter) CalculateEvents(userId uint, earliest time.Time, latest time.Time) ([]model.TimedEvent, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "events", time.Now())
+	userData, err := r.UserDao.GetInRange(int64(userId), earliest, latest)
+	if err != nil {
+		return nil, err
+	}
+	var events []model.TimedEvent
+	var detector model.HappyJumpDetector
+	for i := 1; i < len(userData); i++ {
+		prev := userData[i-1]
+		next := userData[i]
+		udiff := prev.Document.DiffAt(next.Document, prev.Timestamp, next.Timestamp)
+		for _, e := range append(udiff.GetEvents(), udiff.GetItemEvents()...) {
+			events = append(events, model.TimedEvent{Event: e, From: prev.Timestamp, To: next.Timestamp})
+		}
+		// Spans the whole jump, from the first stacking snapshot to the happy reset
+		if jump := detector.Next(udiff, prev.Timestamp, next.Timestamp); jump != nil {
+			events = append(events, model.TimedEvent{Event: jump.Event(), From: jump.Started, To: jump.Ended})
+		}
+	}
+	return events, nil
+}
+
+// Team leaderboards for the competition; members only count for the time they were on
+// their team
+func (r Reporter) CalculateTeams(c model.Competition) ([]model.TeamSummary, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "teams", time.Now())
+	var teams []model.TeamSummary
+	for _, team := range c.Teams {
+		var members []model.UserSummary
+		for _, member := range team.Members {
+			begin, end, ok := c.MemberRange(member)
+			if !ok {
+				continue
+			}
+			summary := model.UserSummary{User: member.UserId}
+			if _, err := r.addUserSummary(&summary, int64(member.UserId), begin, end, nil); err != nil {
+				return nil, err
+			}
+			members = append(members, summary)
+		}
+		ApplyScoring(members, c.Scoring)
+		teams = append(teams, model.NewTeamSummary(team.Name, members))
+	}
+	return teams, nil
+}
+
+// Diffs consecutive snapshots into the summary, applying the anomaly policy to suspicious diffs.
+// Counted diffs are also added to ts when it isn't nil
+func (r Reporter) addToSummary(summary *model.UserSummary, userData []rethinkdb.RethinkTornUser, ts *model.TimeSeries) []model.Anomaly {
+	if len(userData) > 0 && summary.StartingStats == 0 {
+		summary.StartingStats = userData[0].Document.BattleStats.Total()
+	}
+	var diffs []model.UserDiff
+	var ratios []float64
+	var refills model.RefillReconciler
+	for i := 0; i < len(userData)-1; i++ {
+		udiff := userData[i].DiffTo(userData[i+1])
+		refills.Reconcile(&udiff)
+		diffs = append(diffs, udiff)
+		trained := udiff.CalculateEnergyTrained()
+		gains, _ := udiff.BattleStats.GetTotalGains().Float64()
+		if trained > 0 && gains > 0 {
+			ratios = append(ratios, gains/float64(trained))
+		}
+	}
+	median := Median(ratios)
+
+	var anomalies []model.Anomaly
+	for i, udiff := range diffs {
+		prev := userData[i]
+		next := userData[i+1]
+		trained := udiff.CalculateEnergyTrained()
+		gains := udiff.BattleStats.GetTotalGains()
+		gainsFloat, _ := gains.Float64()
+		reasons := udiff.Validate()
+		reasons = append(reasons, model.ValidateGainsPerEnergy(gainsFloat, trained, median)...)
+		if len(reasons) > 0 {
+			max := udiff.MaxPlausibleEnergy()
+			anomalies = append(anomalies, model.Anomaly{
+				UserId:    prev.Document.UserId,
+				From:      prev.Timestamp,
+				To:        next.Timestamp,
+				Energy:    trained,
+				MaxEnergy: max,
+				Gains:     gains.Text('f', 4),
+				Reasons:   reasons,
+			})
+			var counted bool
+			if trained, counted = r.applyPolicy(trained, max); !counted {
+				continue
+			}
+		}
+		udiff.AddToSummaryWithEnergy(summary, trained)
+		if ts != nil {
+			ts.AddDiff(udiff, trained, next.Timestamp)
+		}
+	}
+	var streak model.RefillStreakTracker
+	for _, snapshot := range userData {
+		streak.Observe(snapshot.Document, snapshot.Timestamp)
+		if ts != nil {
+			ts.AddSnapshot(snapshot.Document, snapshot.Timestamp)
+		}
+	}
+	summary.RefillStreak = streak.Streak()
+	return anomalies
+}
+
+// Energy counted for a suspicious diff under the anomaly policy; false when it's excluded
+func (r Reporter) applyPolicy(trained int, max int) (int, bool) {
+	if r.AnomalyPolicy == model.AnomalyPolicyExclude {
+		return 0, false
+	} else if r.AnomalyPolicy == model.AnomalyPolicyCap {
+		if trained < 0 {
+			trained = 0
+		} else if trained > max {
+			trained = max
+		}
+	}
+	return trained, true
+}
+
+// Buckets a user's energy trained and stat totals; suspicious diffs are counted under the
+// anomaly policy like they are on the leaderboard
+func (r Reporter) CalculateTimeSeries(userId uint, bucket string, earliest time.Time, latest time.Time) (*model.TimeSeries, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "timeSeries", time.Now())
+	ts, err := model.NewTimeSeries(userId, bucket, earliest, latest)
+	if err != nil {
+		return nil, err
+	}
+	userData, err := r.UserDao.GetInRange(int64(userId), earliest, latest)
+	if err != nil {
+		return nil, err
+	}
+	var refills model.RefillReconciler
+	for i, snapshot := range userData {
+		ts.AddSnapshot(snapshot.Document, snapshot.Timestamp)
+		if i == 0 {
+			continue
+		}
+		prev := userData[i-1]
+		udiff := prev.Document.DiffAt(snapshot.Document, prev.Timestamp, snapshot.Timestamp)
+		refills.Reconcile(&udiff)
+		trained := udiff.CalculateEnergyTrained()
+		if len(udiff.Validate()) > 0 {
+			var counted bool
+			if trained, counted = r.applyPolicy(trained, udiff.MaxPlausibleEnergy()); !counted {
+				continue
+			}
+		}
+		ts.AddDiff(udiff, trained, snapshot.Timestamp)
+	}
+	ts.Finish()
+	return ts, nil
+}
+
+func (r Reporter) CalculateItemLedger(userId uint, earliest time.Time, latest time.Time) (*model.ItemLedger, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "itemLedger", time.Now())
+	userData, err := r.UserDao.GetInRange(int64(userId), earliest, latest)
+	if err != nil {
+		return nil, err
+	}
+	ledger := model.NewItemLedger(userId)
+	for i := 0; i < len(userData)-1; i++ {
+		prev := userData[i]
+		next := userData[i+1]
+		ledger.Add(prev.Document.Diff(next.Document), prev.Timestamp, next.Timestamp)
+		if next.Document.Name != "" {
+			ledger.Name = next.Document.Name
+		}
+	}
+	return ledger, nil
+}
+
+func (r Reporter) CalculateHappyJumps(earliest time.Time, latest time.Time) ([]model.HappyJump, error) {
+	defer tmetrics.ObserveSince(tmetrics.ReporterDuration, "happyJumps", time.Now())
+	userIds, err := r.UserDao.GetUserIds()
+	if err != nil {
+		return nil, err
+	}
+	var jumps []model.HappyJump
+	for _, userId := range userIds {
+		userData, err := r.UserDao.GetInRange(userId, earliest, latest)
+		if err != nil {
+			tlog.WithError(err).WithField("user_id", userId).Errorf("Unable to get history for User")
+		}
+		jumps = append(jumps, FindHappyJumps(userData)...)
+	}
+	sort.SliceStable(jumps, func(i, j int) bool {
+		return jumps[i].Energy > jumps[j].Energy
+	})
+	return jumps, nil
+}
+
+func FindHappyJumps(userData []rethinkdb.RethinkTornUser) []model.HappyJump {
+	var jumps []model.HappyJump
+	var detector model.HappyJumpDetector
+	for i := 0; i < len(userData)-1; i++ {
+		prev := userData[i]
+		next := userData[i+1]
+		udiff := prev.Document.DiffAt(next.Document, prev.Timestamp, next.Timestamp)
+		if jump := detector.Next(udiff, prev.Timestamp, next.Timestamp); jump != nil {
+			jump.Name = next.Document.Name
+			jumps = append(jumps, *jump)
+		}
+	}
+	return jumps
+}
+
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
 }
 
 type KV struct {
@@ -77,6 +348,11 @@ func SortMapByValue(m map[uint]int) []KV {
 }
 
 func RunReport(args Args, done chan bool) {
+	if args.Chains {
+		RunChainReport(args, done)
+		return
+	}
+
 	// Basic data setup
 	isoLayout := "2006-01-02"
 	sEarliest := "2019-08-24"
@@ -84,44 +360,128 @@ func RunReport(args Args, done chan bool) {
 	earliest, aerr := time.Parse(isoLayout, sEarliest)
 	latest, lerr := time.Parse(isoLayout, sLatest)
 	if aerr != nil || lerr != nil {
-		log.Panicf("Invalid report date range: earliest=%s, latest=%s, err1=%s, err2=%s\n",
+		tlog.Panicf("Invalid report date range: earliest=%s, latest=%s, err1=%s, err2=%s",
 			earliest, latest, aerr, lerr)
 	}
 
 	// DI setup
 	session := rethinkdb.SetUpDb(args.RethinkdbServer)
 	defer session.Close()
-	userDao := rethinkdb.UserDao{session}
+	userDao := rethinkdb.UserDao{Session: session}
 
-	energyTrainedPerUser := make(map[uint]int)
+	reporter := Reporter{UserDao: &userDao, AnomalyPolicy: args.AnomalyPolicy}
+
+	var summaries []model.UserSummary
+	var anomalies []model.Anomaly
+	var jumps []model.HappyJump
 	go func() {
-		userIds, err := userDao.GetUserIds()
+		var err error
+		summaries, anomalies, err = reporter.CalculateEnergyTrained(earliest, latest)
+		if err != nil {
+			tlog.Panicf("Unable to calculate energy trained: %s", err)
+		}
+		jumps, err = reporter.CalculateHappyJumps(earliest, latest)
 		if err != nil {
-			log.Panicf("Unable to get User IDs: %s", err)
+			tlog.Panicf("Unable to calculate happy jumps: %s", err)
 		}
-		log.Printf("Found %d distinct User IDs: %v", len(userIds), userIds)
+		done <- true
+	}()
 
-		for u := 0; u < len(userIds); u++ {
-			userId := userIds[u]
-			userData, err := userDao.GetInRange(userId, earliest, latest)
-			if err != nil {
-				log.Printf("Unable to get history for User: id=%d, err=%s\n", userId, err)
-			}
-			for i := 0; i < len(userData) - 1; i++ {
-				prev := userData[i]
-				next := userData[i+1]
-				udiff := prev.Document.Diff(next.Document)
-				eTrained := udiff.CalculateEnergyTrained()
-				energyTrainedPerUser[prev.Document.UserId] += eTrained
-			}
+	<- done
+
+	for rank, summary := range summaries {
+		fmt.Println(FormatSummary(rank, summary))
+	}
+	if len(jumps) > 0 {
+		fmt.Printf("\n%d happy jumps:\n", len(jumps))
+		for _, jump := range jumps {
+			fmt.Println(FormatHappyJump(jump))
+		}
+	}
+	if len(anomalies) > 0 {
+		fmt.Printf("\n%d suspicious diffs (policy=%s):\n", len(anomalies), args.AnomalyPolicy)
+		for _, a := range anomalies {
+			fmt.Println(FormatAnomaly(a))
+		}
+	}
+}
+
+func FormatHappyJump(hj model.HappyJump) string {
+	return fmt.Sprintf("[%d (%s)] %s: %de trained at %d happy, %s gains [edvds=%d, ecstasy=%d, xan=%d] (stacked %s)",
+		hj.UserId, hj.Name, hj.Trained.Format(time.RFC3339), hj.Energy, hj.PeakHappy, hj.Gains, hj.EDVDs, hj.Ecstasy, hj.Xanax,
+		hj.Trained.Sub(hj.Started).Round(time.Minute))
+}
+
+func FormatAnomaly(a model.Anomaly) string {
+	return fmt.Sprintf("[%d (%s)] %s - %s: %de trained (max %d), %s gains: %s", a.UserId, a.Name,
+		a.From.Format(time.RFC3339), a.To.Format(time.RFC3339), a.Energy, a.MaxEnergy, a.Gains, strings.Join(a.Reasons, "; "))
+}
+
+func FormatSummary(rank int, ue model.UserSummary) string {
+	prefixes := []string{"fhc", "xan", "prf", "lsd", "cans", "edvds", "jpEnergy", "attacks", "ods"}
+	values := []int{ue.FHCs, ue.Xanax, ue.EnergyRefills, ue.LSD, ue.EnergyDrinks, ue.EDVDs, ue.JpEnergy, ue.Attacks, ue.Overdoses}
+	var sources strings.Builder
+	for i, v := range values {
+		if v > 0 {
+			sources.WriteString(fmt.Sprintf("%s=%d, ", prefixes[i], v))
+		}
+	}
+	var gains string
+	if ue.TotalGains > 0 {
+		gains = fmt.Sprintf(" gained [str=%.0f, spd=%.0f, dex=%.0f, def=%.0f] %.0f per 1000e at %d happy",
+			ue.StrengthGains, ue.SpeedGains, ue.DexterityGains, ue.DefenseGains, ue.GainsPerKiloEnergy, ue.TrainHappy)
+	}
+	var streak string
+	if ue.RefillStreak.DaysUsed > 0 {
+		streak = fmt.Sprintf(" refilled %d days (streak %d, longest %d)", ue.RefillStreak.DaysUsed,
+			ue.RefillStreak.CurrentStreak, ue.RefillStreak.LongestStreak)
+	}
+	var gaps string
+	if ue.DataGaps > 0 {
+		gaps = fmt.Sprintf(" (%d data gaps, longest %s, %d low confidence)", ue.DataGaps, ue.LongestGap.Round(time.Minute), ue.LowConfidence)
+	}
+	return fmt.Sprintf("#%d [%d (%s)] %d trained [%s]%s%s%s", rank+1, ue.User, ue.Name, ue.Energy,
+		strings.TrimSuffix(sources.String(), ", "), gains, streak, gaps)
+}
+
+func RunChainReport(args Args, done chan bool) {
+	earliest := time.Date(2019, time.August, 24, 0, 0, 0, 0, time.UTC)
+	latest := time.Date(2019, time.August, 31, 0, 0, 0, 0, time.UTC)
+
+	session := rethinkdb.SetUpDb(args.RethinkdbServer)
+	defer session.Close()
+	attackDao := rethinkdb.AttackDao{Session: session}
+	reporter := Reporter{AttackDao: &attackDao, FactionId: args.FactionId}
+
+	var chains []model.Chain
+	go func() {
+		var err error
+		chains, err = reporter.CalculateChains(earliest, latest)
+		if err != nil {
+			tlog.Panicf("Unable to calculate chains: %s", err)
 		}
 		done <- true
 	}()
 
 	<- done
 
-	sorted := SortMapByValue(energyTrainedPerUser)
-	for _, v := range sorted {
-		fmt.Printf("%d: %d energy trained\n", v.Key, v.Value)
+	for _, chain := range chains {
+		fmt.Println(FormatChain(chain))
+	}
+}
+
+func FormatChain(chain model.Chain) string {
+	var sb strings.Builder
+	started := time.Unix(int64(chain.Started), 0).UTC()
+	ended := time.Unix(int64(chain.Ended), 0).UTC()
+	sb.WriteString(fmt.Sprintf("Chain of %d hits [%s - %s]: %.2f respect, %d bonus hits, timedOut=%t\n",
+		chain.Length, started.Format(time.RFC3339), ended.Format(time.RFC3339), chain.Respect, chain.BonusHits, chain.TimedOut))
+	for _, m := range chain.Members {
+		sb.WriteString(fmt.Sprintf("  [%d (%s)] %d hits, %.2f respect, %d bonus hits, %.2f avg fair fight\n",
+			m.UserId, m.Name, m.Hits, m.Respect, m.BonusHits, m.FairFight))
 	}
-}
\ No newline at end of file
+	for _, g := range chain.Gaps {
+		sb.WriteString(fmt.Sprintf("  gap of %ds after hit #%d\n", g.Seconds, g.After))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,251 @@
+package twebhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"torn/model"
+	"torn/tlog"
+	"torn/tmetrics"
+)
+
+// Subscribers verify SignatureHeader as "sha256=" followed by the hex HMAC-SHA256 of the
+// body keyed with their secret
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+const (
+	DefaultRetries         = 5
+	DefaultBackoff         = time.Second
+	DefaultRefreshInterval = time.Minute
+	queueSize              = 1000
+)
+
+type Payload struct {
+	Delivery      string    `json:"delivery"`
+	Type          string    `json:"type"`
+	Text          string    `json:"text"`
+	UserId        uint      `json:"userId"`
+	Name          string    `json:"name"`
+	EnergyTrained int       `json:"energyTrained"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+}
+
+type Store interface {
+	GetSubscriptions() ([]model.WebhookSubscription, error)
+	LogDelivery(delivery model.WebhookDelivery) error
+}
+
+type job struct {
+	subscription model.WebhookSubscription
+	payload      Payload
+}
+
+// Sends events to matching subscriptions from a pool of workers, retrying failed
+// deliveries with exponential backoff and logging the outcome to the Store
+type Dispatcher struct {
+	Store           Store
+	Client          *http.Client
+	Retries         int
+	Backoff         time.Duration // doubled after each failed attempt
+	RefreshInterval time.Duration
+
+	mux           sync.Mutex
+	subscriptions []model.WebhookSubscription
+	refreshed     time.Time
+	jobs          chan job
+	wg            sync.WaitGroup
+	closing       sync.RWMutex // held for writing while jobs is closed
+	closed        bool
+}
+
+func NewDispatcher(store Store, retries int) *Dispatcher {
+	return &Dispatcher{
+		Store:           store,
+		Client:          &http.Client{Timeout: time.Second * 10},
+		Retries:         retries,
+		Backoff:         DefaultBackoff,
+		RefreshInterval: DefaultRefreshInterval,
+		jobs:            make(chan job, queueSize),
+	}
+}
+
+func (d *Dispatcher) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for j := range d.jobs {
+				d.deliver(j)
+			}
+		}()
+	}
+}
+
+// Stops accepting events and waits up to timeout for queued deliveries, including their
+// retries. Returns false if deliveries were still running when it gave up
+func (d *Dispatcher) Close(timeout time.Duration) bool {
+	d.closing.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.jobs)
+	}
+	d.closing.Unlock()
+	finished := make(chan bool)
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Secret for subscriptions registered without one
+func NewSecret() string {
+	return randomHex(32)
+}
+
+// Cached subscriptions, refreshed from the Store every RefreshInterval
+func (d *Dispatcher) getSubscriptions() []model.WebhookSubscription {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if time.Since(d.refreshed) < d.RefreshInterval {
+		return d.subscriptions
+	}
+	subscriptions, err := d.Store.GetSubscriptions()
+	if err != nil {
+		// Keep the last good set until the next refresh
+		tlog.WithError(err).Errorf("Unable to refresh webhook subscriptions")
+	} else {
+		d.subscriptions = subscriptions
+	}
+	d.refreshed = time.Now()
+	return d.subscriptions
+}
+
+// Queues events from one diff; nil-safe so the consumer can run without webhooks
+func (d *Dispatcher) Publish(userId uint, name string, events []model.Event, trained int, from time.Time, to time.Time) {
+	if d == nil || len(events) == 0 {
+		return
+	}
+	d.closing.RLock()
+	defer d.closing.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, subscription := range d.getSubscriptions() {
+		for _, e := range events {
+			if !subscription.Matches(e.Type, trained) {
+				continue
+			}
+			payload := Payload{
+				Delivery:      randomHex(16),
+				Type:          e.Type,
+				Text:          e.Text,
+				UserId:        userId,
+				Name:          name,
+				EnergyTrained: trained,
+				From:          from,
+				To:            to,
+			}
+			select {
+			case d.jobs <- job{subscription, payload}:
+			default:
+				tmetrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+				tlog.WithFields(tlog.Fields{"subscription_id": subscription.Id, "event": e.Type}).Warnf("Webhook queue full, dropping event")
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(j job) {
+	delivery := model.WebhookDelivery{
+		Id:             j.payload.Delivery,
+		SubscriptionId: j.subscription.Id,
+		EventType:      j.payload.Type,
+		UserId:         j.payload.UserId,
+	}
+	body, err := json.Marshal(j.payload)
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		backoff := d.Backoff
+		for attempt := 1; attempt <= d.Retries+1; attempt++ {
+			delivery.Attempts = attempt
+			delivery.Time = time.Now()
+			retry := d.attempt(j, body, &delivery)
+			if delivery.Delivered || !retry || attempt > d.Retries {
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	logger := tlog.WithFields(tlog.Fields{"subscription_id": delivery.SubscriptionId, "delivery": delivery.Id, "attempts": delivery.Attempts})
+	if delivery.Delivered {
+		tmetrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		logger.Debugf("Delivered webhook")
+	} else {
+		tmetrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+		logger.WithField("error", delivery.Error).Warnf("Unable to deliver webhook")
+	}
+	if err := d.Store.LogDelivery(delivery); err != nil {
+		logger.WithError(err).Errorf("Unable to log webhook delivery")
+	}
+}
+
+// Returns whether a failed attempt is worth retrying
+func (d *Dispatcher) attempt(j job, body []byte, delivery *model.WebhookDelivery) bool {
+	req, err := http.NewRequest(http.MethodPost, j.subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(j.subscription.Secret, body))
+	req.Header.Set(DeliveryHeader, j.payload.Delivery)
+	req.Header.Set(EventHeader, j.payload.Type)
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		delivery.StatusCode = 0
+		delivery.Error = err.Error()
+		return true
+	}
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode/100 == 2 {
+		delivery.Delivered = true
+		delivery.Error = ""
+		return false
+	}
+	delivery.Error = fmt.Sprintf("subscriber responded with status %d", resp.StatusCode)
+	// Client errors won't change on retry, apart from rate limiting
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
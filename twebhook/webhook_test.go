@@ -0,0 +1,120 @@
+package twebhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"torn/model"
+)
+
+type fakeStore struct {
+	subscriptions []model.WebhookSubscription
+	mux           sync.Mutex
+	deliveries    []model.WebhookDelivery
+}
+
+func (f *fakeStore) GetSubscriptions() ([]model.WebhookSubscription, error) {
+	return f.subscriptions, nil
+}
+
+func (f *fakeStore) LogDelivery(delivery model.WebhookDelivery) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.deliveries = append(f.deliveries, delivery)
+	return nil
+}
+
+// Fails each subscriber's first request with a 500, rejects bad signatures with a 400
+type receiver struct {
+	t        *testing.T
+	mux      sync.Mutex
+	requests map[string]int
+	received map[string][]Payload
+}
+
+func (rc *receiver) handler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, body) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rc.mux.Lock()
+		defer rc.mux.Unlock()
+		rc.requests[r.URL.Path] += 1
+		if rc.requests[r.URL.Path] == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			rc.t.Errorf("Unable to decode payload: %v", err)
+		}
+		if r.Header.Get(DeliveryHeader) != payload.Delivery || r.Header.Get(EventHeader) != payload.Type {
+			rc.t.Errorf("headers don't match payload %+v", payload)
+		}
+		rc.received[r.URL.Path] = append(rc.received[r.URL.Path], payload)
+	}
+}
+
+func TestDispatcher_Publish(t *testing.T) {
+	rc := &receiver{t: t, requests: make(map[string]int), received: make(map[string][]Payload)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/overdoses", rc.handler("s1"))
+	mux.HandleFunc("/trains", rc.handler("s2"))
+	mux.HandleFunc("/unsigned", rc.handler("other"))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	store := &fakeStore{subscriptions: []model.WebhookSubscription{
+		{Id: "overdoses", Url: server.URL + "/overdoses", Secret: "s1", EventTypes: []string{model.EventOverdose}},
+		{Id: "trains", Url: server.URL + "/trains", Secret: "s2", EventTypes: []string{model.EventTrain}, MinEnergy: 1000},
+		{Id: "unsigned", Url: server.URL + "/unsigned", Secret: "s3", EventTypes: []string{model.EventOverdose}},
+	}}
+	d := NewDispatcher(store, 2)
+	d.Backoff = time.Millisecond
+	d.Start(2)
+	from := time.Date(2019, time.September, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Minute)
+	d.Publish(1, "Alice", []model.Event{{Type: model.EventOverdose, Text: "overdosed, RIP"}, {Type: model.EventTrain, Text: "trained 500e"}}, 500, from, to)
+	d.Publish(2, "Bob", []model.Event{{Type: model.EventTrain, Text: "trained 1500e"}}, 1500, from, to)
+	if !d.Close(time.Second * 5) {
+		t.Fatalf("Close() timed out")
+	}
+	// Events after Close are dropped rather than panicking
+	d.Publish(3, "Carol", []model.Event{{Type: model.EventOverdose, Text: "overdosed, RIP"}}, 0, from, to)
+
+	if got := rc.received["/overdoses"]; len(got) != 1 || got[0].UserId != 1 || got[0].Text != "overdosed, RIP" || !got[0].To.Equal(to) {
+		t.Errorf("overdoses received %+v, want Alice's overdose", got)
+	}
+	if got := rc.received["/trains"]; len(got) != 1 || got[0].UserId != 2 || got[0].EnergyTrained != 1500 {
+		t.Errorf("trains received %+v, want only Bob's 1500e train", got)
+	}
+	sort.Slice(store.deliveries, func(i, j int) bool {
+		return store.deliveries[i].SubscriptionId < store.deliveries[j].SubscriptionId
+	})
+	want := []model.WebhookDelivery{
+		{SubscriptionId: "overdoses", EventType: model.EventOverdose, UserId: 1, Attempts: 2, StatusCode: 200, Delivered: true},
+		{SubscriptionId: "trains", EventType: model.EventTrain, UserId: 2, Attempts: 2, StatusCode: 200, Delivered: true},
+		// Signature failures are client errors, so they aren't retried
+		{SubscriptionId: "unsigned", EventType: model.EventOverdose, UserId: 1, Attempts: 1, StatusCode: 400,
+			Error: "subscriber responded with status 400"},
+	}
+	if len(store.deliveries) != len(want) {
+		t.Fatalf("deliveries = %+v, want %d", store.deliveries, len(want))
+	}
+	for i, delivery := range store.deliveries {
+		if delivery.Id == "" || delivery.Time.IsZero() {
+			t.Errorf("delivery %+v missing id or time", delivery)
+		}
+		delivery.Id, delivery.Time = "", time.Time{}
+		if delivery != want[i] {
+			t.Errorf("delivery = %+v, want %+v", delivery, want[i])
+		}
+	}
+}